@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"github.com/johnmuirjr/choose-donation-assets/donation"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputSuffix ends the names of the files that solveDirectory writes.
+const outputSuffix = ".out.json"
+
+// solveDirectory solves the input in each *.json file in inputDir
+// (in name order, skipping the *.out.json files that it writes)
+// as an independent problem and writes each output
+// to a file in outputDir named like the input file but ending in .out.json.
+// It reports the files that fail on standard error as it solves them
+// and summarizes the results at the end,
+// and it returns the program's exit status.
+func (c *command) solveDirectory(inputDir, outputDir string, opts donation.Options) int {
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		c.reportError(2, fmt.Sprintf("error reading input directory %s: %v", inputDir, err))
+		return 2
+	}
+	if err = os.MkdirAll(outputDir, 0755); err != nil {
+		c.reportError(2, fmt.Sprintf("error creating output directory %s: %v", outputDir, err))
+		return 2
+	}
+	type result struct {
+		name, outputPath string
+		failed           bool
+	}
+	var results []result
+	failures := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, outputSuffix) {
+			continue
+		}
+		outputPath := filepath.Join(outputDir, strings.TrimSuffix(name, ".json")+outputSuffix)
+		prefix := name + ": "
+		if err = c.solveFile(filepath.Join(inputDir, name), outputPath, opts, prefix); err != nil {
+			c.reportError(2, fmt.Sprintf("%s%v", prefix, err))
+			failures++
+		}
+		results = append(results, result{name, outputPath, err != nil})
+	}
+	for _, r := range results {
+		if r.failed {
+			fmt.Fprintf(c.stderr, "failed: %s\n", r.name)
+		} else {
+			fmt.Fprintf(c.stderr, "ok: %s -> %s\n", r.name, r.outputPath)
+		}
+	}
+	fmt.Fprintf(c.stderr, "%d files: %d succeeded, %d failed\n", len(results), len(results)-failures, failures)
+	if failures > 0 && !c.continueOnError {
+		return 2
+	}
+	return 0
+}
+
+// solveFile solves the input in the file at inputPath
+// and writes the output to the file at outputPath
+// (which it leaves alone if solving fails).
+func (c *command) solveFile(inputPath, outputPath string, opts donation.Options, prefix string) error {
+	input, err := c.readInput(inputPath)
+	if err != nil {
+		return err
+	}
+	if c.pricesPath != "" {
+		if err = c.readPrices(c.pricesPath, &input); err != nil {
+			return err
+		}
+	}
+	output, err := c.solve(input, opts, prefix)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating output file %s: %w", outputPath, err)
+	}
+	err = c.writeJSON(file, &output, c.pretty)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing output file %s: %w", outputPath, err)
+	}
+	return nil
+}
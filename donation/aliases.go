@@ -0,0 +1,114 @@
+package donation
+
+import (
+	"fmt"
+)
+
+// CanonicalName returns the asset name that name is an alias of
+// in i.Aliases or name if it is not an alias.
+func (i *Input) CanonicalName(name string) string {
+	if canonical, ok := i.Aliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// checkAliases returns an error if an alias in i.Aliases
+// is also the name of an asset in i.AssetSharePrices
+// or the canonical name of another alias.
+func (i *Input) checkAliases() error {
+	for alias, canonical := range i.Aliases {
+		if _, ok := i.AssetSharePrices[alias]; ok {
+			return fmt.Errorf(`alias %s of %s is also in assetSharePrices`, alias, canonical)
+		}
+		if _, ok := i.Aliases[canonical]; ok {
+			return fmt.Errorf(`alias %s names %s, which is also an alias`, alias, canonical)
+		}
+	}
+	return nil
+}
+
+// resolveAliases returns a copy of i without aliases whose lots,
+// recent purchases, and prior donations have the canonical names of their assets
+// (see CanonicalName) and whose lots remember their original names
+// (see Output.restoreAliasNames).
+func (i *Input) resolveAliases() (resolved Input, err error) {
+	if err = i.checkAliases(); err != nil {
+		return
+	}
+	resolved = *i
+	resolved.Aliases = nil
+	resolved.Lots = make([]LotJSON, len(i.Lots))
+	for m, lot := range i.Lots {
+		if canonical := i.CanonicalName(lot.AssetName); canonical != lot.AssetName {
+			lot.alias = lot.AssetName
+			lot.AssetName = canonical
+		}
+		resolved.Lots[m] = lot
+	}
+	resolved.RecentPurchases = make([]Purchase, len(i.RecentPurchases))
+	for m, purchase := range i.RecentPurchases {
+		purchase.AssetName = i.CanonicalName(purchase.AssetName)
+		resolved.RecentPurchases[m] = purchase
+	}
+	resolved.AlreadyDonated = make([]PriorDonation, len(i.AlreadyDonated))
+	for m, donation := range i.AlreadyDonated {
+		donation.AssetName = i.CanonicalName(donation.AssetName)
+		resolved.AlreadyDonated[m] = donation
+	}
+	return
+}
+
+// canonicalNames returns the canonical names of names
+// (or nil if names is nil).
+func (i *Input) canonicalNames(names []string) (canonical []string) {
+	if names == nil {
+		return nil
+	}
+	canonical = make([]string, len(names))
+	for m, name := range names {
+		canonical[m] = i.CanonicalName(name)
+	}
+	return
+}
+
+// applyAliases resolves input's aliases (see resolveAliases)
+// and the asset names in opts.
+func applyAliases(input *Input, opts *Options) (err error) {
+	if len(input.Aliases) == 0 {
+		return nil
+	}
+	opts.ExcludeAssets = input.canonicalNames(opts.ExcludeAssets)
+	opts.OnlyAssets = input.canonicalNames(opts.OnlyAssets)
+	*input, err = input.resolveAliases()
+	return
+}
+
+// restoreAliasNames gives output's lots the asset names
+// that they had in the input before resolveAliases.
+func (output *Output) restoreAliasNames() {
+	restore := func(lot *LotJSON) {
+		if lot.alias != "" {
+			lot.AssetName, lot.alias = lot.alias, lot.AssetName
+		}
+	}
+	for m := range output.Lots {
+		restore(&output.Lots[m].LotJSON)
+	}
+	for m := range output.ExcludedLots {
+		restore(&output.ExcludedLots[m].LotJSON)
+	}
+	for m := range output.RemainingLots {
+		restore(&output.RemainingLots[m])
+	}
+	for m := range output.Alternatives {
+		for n := range output.Alternatives[m].Lots {
+			restore(&output.Alternatives[m].Lots[n].LotJSON)
+		}
+	}
+	for m := range output.Charities {
+		for n := range output.Charities[m].Lots {
+			restore(&output.Charities[m].Lots[n].LotJSON)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strings"
+)
+
+// Alternative is a donation nearly as good as an Output's donation.
+type Alternative struct {
+	Lots              []OutputLot             `json:"donation"`
+	TotalValue        decimal.Decimal         `json:"totalValue"`
+	TotalCapitalGains decimal.Decimal         `json:"totalCapitalGains"`
+	RemainingBudget   decimal.Decimal         `json:"remainingBudget"`
+	AssetSummary      map[string]AssetSummary `json:"assetSummary"`
+}
+
+// FindAlternatives returns up to opts.Alternatives distinct donations
+// other than best (which chooseLots returned)
+// whose total Values are at most opts.AlternativesTolerance less than best's
+// (and that have at most opts.MaxLots lots if it is positive),
+// from the greatest total Value to the least.
+// It finds them by solving the problem again without each lot of best
+// in turn, so each alternative lacks at least one of best's lots
+// (other than pinned lots, which every alternative has).
+// If Options.Timeout stops a solve, it returns the alternatives
+// that it found before the deadline.
+func (nl *NormalizedLots) FindAlternatives(opts *Options, best []Lot) (alternatives [][]Lot, err error) {
+	tolerance, ok := ShiftToInteger(opts.AlternativesTolerance.Shift(-nl.sharePriceExponent-nl.shareExponent).Floor(), 0)
+	if !ok {
+		err = fmt.Errorf(`alternatives tolerance is too large: %s`, opts.AlternativesTolerance)
+		return
+	}
+	bestValue := nl.totalValue(best)
+	seen := map[string]bool{lotsKey(best): true}
+	type candidate struct {
+		lots  []Lot
+		value int64
+	}
+	var candidates []candidate
+	lots := nl.lots
+	defer func() { nl.lots = lots }()
+	for _, banned := range best {
+		if banned.json.Pinned {
+			continue
+		}
+		nl.lots = make([]Lot, 0, len(lots))
+		for _, lot := range lots {
+			if lot.json != banned.json {
+				nl.lots = append(nl.lots, lot)
+			}
+		}
+		var alternative []Lot
+		if alternative, err = nl.chooseLots(opts); nl.timedOut(opts, err) {
+			opts.logf(VerbosityInfo, "alternatives solver did not finish within %v, so keeping the alternatives found so far", opts.Timeout)
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+		key := lotsKey(alternative)
+		value := nl.totalValue(alternative)
+		if seen[key] || len(alternative) == 0 || bestValue-value > int64(tolerance) || (opts.MaxLots > 0 && len(alternative) > opts.MaxLots) {
+			continue
+		}
+		seen[key] = true
+		candidates = append(candidates, candidate{alternative, value})
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].value > candidates[b].value })
+	for m := 0; m < len(candidates) && m < opts.Alternatives; m++ {
+		alternatives = append(alternatives, candidates[m].lots)
+	}
+	return
+}
+
+// totalValue returns the total knapsack value of lots.
+func (nl *NormalizedLots) totalValue(lots []Lot) (total int64) {
+	for m := range lots {
+		total += nl.ItemValue(&lots[m])
+	}
+	return
+}
+
+// lotsKey returns a string that identifies the lots and share units of lots
+// regardless of their order.
+func lotsKey(lots []Lot) string {
+	parts := make([]string, len(lots))
+	for m, lot := range lots {
+		parts[m] = fmt.Sprintf("%d:%d", lot.index, lot.shares)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,126 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+// checkIntegerCents returns an error naming the first share price,
+// cost, or cash amount in input or the donation amount (if it is not nil)
+// that is not a whole number of cents (see Options.IntegerCents).
+func checkIntegerCents(input *Input, donation *decimal.Decimal) error {
+	if donation != nil && !donation.IsInteger() {
+		return fmt.Errorf(`donation amount must be a whole number of cents: %s`, donation)
+	}
+	for _, name := range input.assetNames() {
+		if price := input.AssetSharePrices[name]; !price.IsInteger() {
+			return fmt.Errorf(`share price of %s must be a whole number of cents: %s`, name, price)
+		}
+	}
+	for _, lot := range input.Lots {
+		if lot.Cash {
+			if !lot.Shares.IsInteger() || (lot.MaxDonatableShares != nil && !lot.MaxDonatableShares.IsInteger()) {
+				return fmt.Errorf(`cash lot %s acquired on %s must have a whole number of cents: %s`, lot.AssetName, lot.Date, lot.Shares)
+			}
+			continue
+		}
+		if !lot.ShareCost.IsInteger() {
+			return fmt.Errorf(`lot of %s acquired on %s must have a shareCost of a whole number of cents: %s`, lot.AssetName, lot.Date, lot.ShareCost)
+		}
+		if lot.SharePrice != nil && !lot.SharePrice.IsInteger() {
+			return fmt.Errorf(`lot of %s acquired on %s must have a sharePrice of a whole number of cents: %s`, lot.AssetName, lot.Date, *lot.SharePrice)
+		}
+	}
+	return nil
+}
+
+// CentsToDollars converts the monetary values of output
+// from an input with Options.IntegerCents into dollars (see mapMoney).
+// Like Round, it only changes how output is presented,
+// so call it after Optimize (and before Round).
+func (output *Output) CentsToDollars() {
+	output.mapMoney(func(d decimal.Decimal) decimal.Decimal { return d.Shift(-2) })
+}
+
+// mapMoney replaces each of the monetary values of output
+// with toDollars's result: the totals that Round rounds,
+// assetSharePrices, the marginal step,
+// and the share costs, share prices, and cash amounts of the lots
+// (including the excluded and remaining lots
+// and those of the alternatives and charities).
+func (output *Output) mapMoney(toDollars func(decimal.Decimal) decimal.Decimal) {
+	output.mapTotals(toDollars)
+
+	// Copy the prices because they may belong to the caller's Input.
+	prices := make(map[string]decimal.Decimal, len(output.AssetSharePrices))
+	for name, price := range output.AssetSharePrices {
+		prices[name] = toDollars(price)
+	}
+	output.AssetSharePrices = prices
+	if output.Marginal != nil {
+		output.Marginal.Step = toDollars(output.Marginal.Step)
+	}
+
+	cashAssets := make(map[string]bool)
+	convertLot := func(lot *LotJSON) {
+		if lot.Cash {
+			cashAssets[lot.AssetName] = true
+			lot.Shares = toDollars(lot.Shares)
+			if lot.MaxDonatableShares != nil {
+				maxShares := toDollars(*lot.MaxDonatableShares)
+				lot.MaxDonatableShares = &maxShares
+			}
+			return
+		}
+		lot.ShareCost = toDollars(lot.ShareCost)
+		if lot.SharePrice != nil {
+			price := toDollars(*lot.SharePrice)
+			lot.SharePrice = &price
+		}
+	}
+	convertLots := func(lots []OutputLot) {
+		for m := range lots {
+			convertLot(&lots[m].LotJSON)
+			if lots[m].Cash {
+				lots[m].OriginalShares = toDollars(lots[m].OriginalShares)
+			}
+		}
+	}
+	convertLots(output.Lots)
+	for m := range output.ExcludedLots {
+		convertLot(&output.ExcludedLots[m].LotJSON)
+	}
+	for m := range output.RemainingLots {
+		convertLot(&output.RemainingLots[m])
+	}
+	for m := range output.Alternatives {
+		convertLots(output.Alternatives[m].Lots)
+	}
+	for m := range output.Charities {
+		convertLots(output.Charities[m].Lots)
+	}
+
+	// The shares of cash assets are also amounts of cents.
+	convertSummaries := func(summaries map[string]AssetSummary) {
+		for name, summary := range summaries {
+			if cashAssets[name] {
+				summary.Shares = toDollars(summary.Shares)
+				summaries[name] = summary
+			}
+		}
+	}
+	convertSummaries(output.AssetSummary)
+	for m := range output.Alternatives {
+		convertSummaries(output.Alternatives[m].AssetSummary)
+	}
+	for m := range output.Charities {
+		convertSummaries(output.Charities[m].AssetSummary)
+	}
+}
+
+// CentsToDollars converts both recommendations in comparison
+// (see Output.CentsToDollars).
+func (comparison *Comparison) CentsToDollars() {
+	comparison.GainsRecommendation.CentsToDollars()
+	comparison.LossesRecommendation.CentsToDollars()
+}
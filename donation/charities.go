@@ -0,0 +1,229 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+	"time"
+)
+
+// CharityDonation is the part of a split donation that goes to one charity.
+type CharityDonation struct {
+	Name              string                  `json:"name"`
+	Lots              []OutputLot             `json:"donation"`
+	DonationAmount    decimal.Decimal         `json:"donationAmount"`
+	TotalValue        decimal.Decimal         `json:"totalValue"`
+	TotalCapitalGains decimal.Decimal         `json:"totalCapitalGains"`
+	RemainingBudget   decimal.Decimal         `json:"remainingBudget"`
+	Budget            *decimal.Decimal        `json:"budget,omitempty"`
+	AssetSummary      map[string]AssetSummary `json:"assetSummary"`
+}
+
+// OptimizeCharities splits a donation among input's charities.
+// It greedily calls Optimize for each charity in turn
+// with the charity's budget as the donation amount
+// and the shares that earlier charities did not receive
+// (so that no share is donated twice), and it ignores opts.Donation.
+// The output's Charities hold each charity's donation
+// and the rest of the output combines them:
+// its lots are the donated lots in the order in which
+// they were first donated (or sorted with opts.Sort),
+// and its excluded lots are those that Optimize excluded for every charity.
+// A loss cap applies to the combined donation.
+// OptimizeCharities ignores opts.Alternatives.
+// It does not support opts.Fractional, whose fractional shares
+// would make the later charities' problems too precise.
+func OptimizeCharities(input Input, opts Options) (output Output, err error) {
+	if opts.Fractional {
+		err = fmt.Errorf(`fractional donations are incompatible with charities`)
+		return
+	}
+	if opts.AsOf.IsZero() {
+		opts.AsOf = calendarDate(time.Now())
+	}
+	if opts.SaleDate.IsZero() {
+		opts.SaleDate = opts.AsOf
+	}
+	if input.AssetSharePrices == nil {
+		input.AssetSharePrices = make(map[string]decimal.Decimal)
+	}
+	if err = applyAliases(&input, &opts); err != nil {
+		return
+	}
+	if opts.ScaleDecimals != nil && *opts.ScaleDecimals < 0 {
+		err = fmt.Errorf(`scale must not be negative: %d`, *opts.ScaleDecimals)
+		return
+	}
+	applyScale(&input, &opts)
+	config := opts
+	config.Donation = ""
+	if err = checkUnusedAssets(&input, &opts); err != nil {
+		return
+	}
+	if opts.MergeDuplicates {
+		input.Lots = MergeDuplicateLots(input.Lots)
+	} else if opts.RejectDuplicates {
+		if err = CheckDuplicateLots(input.Lots); err != nil {
+			return
+		}
+	}
+	prior, err := subtractPriorDonations(&input)
+	if err != nil {
+		return
+	}
+
+	// The rounds' inputs lack fully donated lots and duplicates.
+	opts.MergeDuplicates, opts.RejectDuplicates, opts.Strict = false, false, false
+	useLossCap := opts.MaximizeLosses && opts.LossCap.IsPositive()
+
+	// remaining holds the shares of input's lots
+	// that earlier charities did not receive.
+	remaining := append([]LotJSON(nil), input.Lots...)
+	donated := make(map[int]*OutputLot)
+	var donatedOrder []int
+	exclusions := make(map[int]int)
+	reasons := make(map[int]ExclusionReason)
+	rounds := 0
+	budget := decimal.Zero
+	output = Output{
+		AssetSharePrices: input.AssetSharePrices,
+		AssetSummary:     make(map[string]AssetSummary)}
+	for c, charity := range input.Charities {
+		charityDonation := CharityDonation{
+			Name:            charity.Name,
+			Lots:            []OutputLot{},
+			DonationAmount:  charity.Budget,
+			RemainingBudget: charity.Budget,
+			AssetSummary:    make(map[string]AssetSummary)}
+		roundOpts := opts
+		roundOpts.Donation = charity.Budget.String()
+		roundOpts.Summary = opts.Summary && c == 0
+		roundOpts.Alternatives = 0
+		roundOpts.RemainingLots = false
+		if useLossCap {
+			roundOpts.LossCap = opts.LossCap.Add(output.TotalCapitalGains)
+		}
+		if !useLossCap || roundOpts.LossCap.IsPositive() {
+			// Optimize the donation of the remaining shares.
+			var roundInput Input
+			var indexes []int
+			roundInput.AssetSharePrices = input.AssetSharePrices
+			roundInput.RecentPurchases = input.RecentPurchases
+			for m := range remaining {
+				if remaining[m].Shares.IsPositive() {
+					roundInput.Lots = append(roundInput.Lots, remaining[m])
+					indexes = append(indexes, m)
+				}
+			}
+			if opts.Explain != nil {
+				fmt.Fprintf(opts.Explain, "charity %s (lot numbers refer to its remaining lots):\n", charity.Name)
+			}
+			var roundOutput Output
+			if roundOutput, err = Optimize(roundInput, roundOpts); err != nil {
+				err = fmt.Errorf(`charity %s: %w`, charity.Name, err)
+				return
+			}
+			rounds++
+			if roundOpts.Summary {
+				output.Eligible = roundOutput.Eligible
+			}
+			for _, lot := range roundOutput.ExcludedLots {
+				exclusions[indexes[lot.index]]++
+				reasons[indexes[lot.index]] = lot.Reason
+			}
+			for _, lot := range roundOutput.Lots {
+				m := indexes[lot.index]
+				shares := lot.Shares
+				lot.LotJSON = input.Lots[m]
+				lot.Shares = shares
+				lot.OriginalShares = input.Lots[m].Shares
+				lot.Partial = shares.LessThan(input.Lots[m].Shares)
+				lot.index = m
+				remaining[m].Shares = remaining[m].Shares.Sub(lot.Shares)
+				if maxShares := remaining[m].MaxDonatableShares; maxShares != nil {
+					remainingMaxShares := maxShares.Sub(lot.Shares)
+					remaining[m].MaxDonatableShares = &remainingMaxShares
+				}
+				if combined, ok := donated[m]; ok {
+					combined.Shares = combined.Shares.Add(lot.Shares)
+					combined.CapitalGains = combined.CapitalGains.Add(lot.CapitalGains)
+					combined.Partial = combined.Shares.LessThan(input.Lots[m].Shares)
+				} else {
+					combined := lot
+					donated[m] = &combined
+					donatedOrder = append(donatedOrder, m)
+				}
+				charityDonation.Lots = append(charityDonation.Lots, lot)
+			}
+			charityDonation.DonationAmount = roundOutput.DonationAmount
+			charityDonation.TotalValue = roundOutput.TotalValue
+			charityDonation.TotalCapitalGains = roundOutput.TotalCapitalGains
+			charityDonation.RemainingBudget = roundOutput.RemainingBudget
+			charityDonation.Budget = roundOutput.Budget
+			charityDonation.AssetSummary = roundOutput.AssetSummary
+		}
+
+		// Add the charity's donation to the combined donation.
+		output.DonationAmount = output.DonationAmount.Add(charityDonation.DonationAmount)
+		budget = budget.Add(charityDonation.TotalValue).Add(charityDonation.RemainingBudget)
+		output.TotalValue = output.TotalValue.Add(charityDonation.TotalValue)
+		output.TotalCapitalGains = output.TotalCapitalGains.Add(charityDonation.TotalCapitalGains)
+		for name, charitySummary := range charityDonation.AssetSummary {
+			summary := output.AssetSummary[name]
+			summary.Shares = summary.Shares.Add(charitySummary.Shares)
+			summary.TotalValue = summary.TotalValue.Add(charitySummary.TotalValue)
+			summary.TotalCapitalGains = summary.TotalCapitalGains.Add(charitySummary.TotalCapitalGains)
+			output.AssetSummary[name] = summary
+		}
+		output.Charities = append(output.Charities, charityDonation)
+	}
+
+	// Compute the rest of the combined donation.
+	output.Lots = make([]OutputLot, len(donatedOrder))
+	for m, index := range donatedOrder {
+		output.Lots[m] = *donated[index]
+	}
+	if opts.Sort {
+		sort.Slice(output.Lots, func(a, b int) bool {
+			return lotLess(&output.Lots[a].LotJSON, &output.Lots[b].LotJSON, output.Lots[a].index, output.Lots[b].index)
+		})
+	}
+	output.RemainingBudget = budget.Sub(output.TotalValue)
+	if !opts.FeePercent.IsZero() {
+		output.Budget = &budget
+	}
+	output.Config = newConfig(&config, output.DonationAmount)
+	for m := range input.Lots {
+		if _, ok := donated[m]; !ok && rounds > 0 && exclusions[m] == rounds {
+			output.ExcludedLots = append(output.ExcludedLots, OutputExcludedLot{LotJSON: input.Lots[m], Reason: reasons[m], index: m})
+		} else if opts.RemainingLots && rounds > 0 {
+			var shares decimal.Decimal
+			if lot, ok := donated[m]; ok {
+				shares = lot.Shares
+			}
+			output.RemainingLots = appendRemainingLot(output.RemainingLots, input.Lots[m], shares)
+		}
+	}
+	if !output.TotalValue.IsZero() {
+		output.GainsRatio = output.TotalCapitalGains.Div(output.TotalValue)
+	}
+	if useLossCap {
+		lossCap := opts.LossCap
+		excessLoss := decimal.Max(output.TotalCapitalGains.Neg().Sub(lossCap), decimal.Zero)
+		output.LossCap = &lossCap
+		output.ExcessLoss = &excessLoss
+	}
+	if opts.CombinePriorDonations {
+		output.Combined = &PriorTotals{
+			TotalValue:        prior.TotalValue.Add(output.TotalValue),
+			TotalCapitalGains: prior.TotalCapitalGains.Add(output.TotalCapitalGains)}
+	}
+	if opts.TermGains {
+		splitGainsByTerm(&input, &output)
+	}
+	estimateTaxSavings(&output, &opts)
+	if opts.KeepAliasNames {
+		output.restoreAliasNames()
+	}
+	return
+}
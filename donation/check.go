@@ -0,0 +1,59 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+// CheckSummary describes an input that Check found valid.
+type CheckSummary struct {
+	Lots            int
+	EligibleLots    int
+	Assets          int
+	RecentPurchases int
+	PriorDonations  int
+	Charities       int
+
+	// donation amount (or, if the input has charities,
+	// the total of their budgets)
+	DonationAmount decimal.Decimal
+}
+
+// Check validates input and opts as Optimize would
+// (including the input's asset names, shares, prices, and donation amount)
+// without choosing a donation.
+// If input has charities, it checks the donation of each charity's budget.
+func Check(input Input, opts Options) (summary CheckSummary, err error) {
+	summary = CheckSummary{
+		Lots:            len(input.Lots),
+		Assets:          len(input.AssetSharePrices),
+		RecentPurchases: len(input.RecentPurchases),
+		PriorDonations:  len(input.AlreadyDonated),
+		Charities:       len(input.Charities)}
+	if len(input.Charities) == 0 {
+		var nl NormalizedLots
+		if nl, _, err = prepare(&input, &opts); err != nil {
+			return
+		}
+		summary.EligibleLots = len(nl.lots)
+		summary.DonationAmount = nl.donationAmount
+		return
+	}
+	for c, charity := range input.Charities {
+		// prepare changes its input and options.
+		charityInput, charityOpts := input, opts
+		charityInput.Charities = nil
+		charityInput.Lots = append([]LotJSON(nil), input.Lots...)
+		charityOpts.Donation = charity.Budget.String()
+		var nl NormalizedLots
+		if nl, _, err = prepare(&charityInput, &charityOpts); err != nil {
+			err = fmt.Errorf(`charity %s: %w`, charity.Name, err)
+			return
+		}
+		if c == 0 {
+			summary.EligibleLots = len(nl.lots)
+		}
+		summary.DonationAmount = summary.DonationAmount.Add(nl.donationAmount)
+	}
+	return
+}
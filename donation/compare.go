@@ -0,0 +1,37 @@
+package donation
+
+import (
+	"fmt"
+)
+
+// Comparison holds the best donations for the same input and budget
+// when maximizing capital gains and when maximizing capital losses.
+type Comparison struct {
+	GainsRecommendation  Output `json:"gainsRecommendation"`
+	LossesRecommendation Output `json:"lossesRecommendation"`
+}
+
+// Compare calls Optimize twice with opts, once maximizing capital gains
+// and once maximizing capital losses (ignoring opts.MaximizeLosses),
+// so that donors can weigh donating appreciated lots
+// against harvesting losses with the same budget.
+func Compare(input Input, opts Options) (comparison Comparison, err error) {
+	opts.MaximizeLosses = false
+	if comparison.GainsRecommendation, err = Optimize(input, opts); err != nil {
+		err = fmt.Errorf(`gains recommendation: %w`, err)
+		return
+	}
+	opts.MaximizeLosses = true
+	if comparison.LossesRecommendation, err = Optimize(input, opts); err != nil {
+		err = fmt.Errorf(`losses recommendation: %w`, err)
+	}
+	return
+}
+
+// Round rounds both recommendations in comparison (see Output.Round).
+func (comparison *Comparison) Round(places int32, mode string, prices bool) error {
+	if err := comparison.GainsRecommendation.Round(places, mode, prices); err != nil {
+		return err
+	}
+	return comparison.LossesRecommendation.Round(places, mode, prices)
+}
@@ -0,0 +1,92 @@
+package donation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"strings"
+)
+
+// WriteCSV writes the donation lots in output as CSV records
+// followed by a summary record containing the totals.
+func WriteCSV(w io.Writer, output *Output) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"assetName", "date", "shares", "shareCost", "sharePrice", "value", "capitalGains"})
+	for _, lot := range output.Lots {
+		price, cost := output.lotPriceAndCost(&lot.LotJSON)
+		writer.Write([]string{
+			lot.AssetName,
+			lot.Date,
+			lot.Shares.String(),
+			cost.String(),
+			price.String(),
+			price.Mul(lot.Shares).String(),
+			price.Sub(cost).Mul(lot.Shares).String()})
+	}
+	writer.Write([]string{"total", "", "", "", "", output.TotalValue.String(), output.TotalCapitalGains.String()})
+	writer.Flush()
+	return writer.Error()
+}
+
+// lotPriceAndCost returns the share price and cost of lot,
+// one of output's lots (see Input.SharePrice).
+func (output *Output) lotPriceAndCost(lot *LotJSON) (price decimal.Decimal, cost decimal.Decimal) {
+	if lot.Cash {
+		return decimal.NewFromInt(1), decimal.NewFromInt(1)
+	}
+	if lot.SharePrice != nil {
+		return *lot.SharePrice, lot.ShareCost
+	}
+	if price, ok := output.AssetSharePrices[lot.AssetName]; ok || lot.alias == "" {
+		return price, lot.ShareCost
+	}
+	return output.AssetSharePrices[lot.alias], lot.ShareCost
+}
+
+// ReadPricesCSV reads share prices with separators from CSV records
+// with assetName and sharePrice columns (named by a header record
+// and in any order, among any other columns).
+func ReadPricesCSV(r io.Reader, separators Separators) (prices map[string]decimal.Decimal, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf(`missing header with assetName and sharePrice columns`)
+	} else if err != nil {
+		return nil, err
+	}
+	nameColumn, priceColumn := -1, -1
+	for m, column := range header {
+		switch strings.TrimSpace(column) {
+		case "assetName":
+			nameColumn = m
+		case "sharePrice":
+			priceColumn = m
+		}
+	}
+	if nameColumn < 0 || priceColumn < 0 {
+		return nil, fmt.Errorf(`header must have assetName and sharePrice columns`)
+	}
+	prices = make(map[string]decimal.Decimal)
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return prices, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if nameColumn >= len(record) || priceColumn >= len(record) {
+			return nil, fmt.Errorf(`row %d: missing assetName or sharePrice`, row)
+		}
+		name := record[nameColumn]
+		price, err := decimal.NewFromString(separators.Normalize(strings.TrimSpace(record[priceColumn])))
+		if err != nil {
+			return nil, fmt.Errorf(`row %d: invalid sharePrice of %s: %q`, row, name, record[priceColumn])
+		}
+		if _, ok := prices[name]; ok {
+			return nil, fmt.Errorf(`row %d: duplicate price of %s`, row, name)
+		}
+		prices[name] = price
+	}
+}
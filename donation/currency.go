@@ -0,0 +1,25 @@
+package donation
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// ConvertCurrency multiplies the monetary values of output (see mapMoney)
+// by rate, the number of units of currency per unit of the input's currency,
+// and sets output's Currency and FXRate.  The config keeps the amounts
+// of the options in the input's currency.
+// Like Round, it only changes how output is presented
+// (the lots that Optimize chooses do not depend on rate),
+// so call it after Optimize (and CentsToDollars) and before Round.
+func (output *Output) ConvertCurrency(rate decimal.Decimal, currency string) {
+	output.mapMoney(func(d decimal.Decimal) decimal.Decimal { return d.Mul(rate) })
+	output.Currency = currency
+	output.FXRate = &rate
+}
+
+// ConvertCurrency converts both recommendations in comparison
+// (see Output.ConvertCurrency).
+func (comparison *Comparison) ConvertCurrency(rate decimal.Decimal, currency string) {
+	comparison.GainsRecommendation.ConvertCurrency(rate, currency)
+	comparison.LossesRecommendation.ConvertCurrency(rate, currency)
+}
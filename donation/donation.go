@@ -0,0 +1,1446 @@
+// Package donation chooses the lots of capital gains assets
+// to donate to maximize capital gains tax savings
+// (or, optionally, the lots to sell before donating
+// to maximize capital losses).
+package donation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/johnmuirjr/go-knapsack"
+	"github.com/shopspring/decimal"
+	"io"
+	"log"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// dateLayouts are the accepted formats of lot dates.
+var dateLayouts = []string{"2006-01-02", time.RFC3339}
+
+// ParseDate parses a date in one of dateLayouts.
+func ParseDate(date string) (t time.Time, err error) {
+	for _, layout := range dateLayouts {
+		if t, err = time.Parse(layout, date); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// calendarDate returns midnight UTC of the date of t (in t's location).
+func calendarDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// HeldDays returns the number of calendar days from the date of acquired
+// to the date of asOf (ignoring their times of day).
+func HeldDays(acquired time.Time, asOf time.Time) int {
+	return int(calendarDate(asOf).Sub(calendarDate(acquired)).Hours() / 24)
+}
+
+// IsLongTerm reports whether an asset acquired on the specified date
+// has been held for at least longTermDays calendar days as of asOf
+// (see HeldDays) or, if longTermDays is zero or negative,
+// for more than one year (also ignoring their times of day).
+func IsLongTerm(acquired time.Time, asOf time.Time, longTermDays int) bool {
+	if longTermDays <= 0 {
+		return calendarDate(asOf).After(calendarDate(acquired).AddDate(1, 0, 0))
+	}
+	return HeldDays(acquired, asOf) >= longTermDays
+}
+
+type LotJSON struct {
+	AssetName string          `json:"assetName"`
+	Date      string          `json:"date"`
+	Shares    decimal.Decimal `json:"shares"`
+	ShareCost decimal.Decimal `json:"shareCost"`
+
+	// the maximum number of shares of this lot to donate (nil for no limit)
+	MaxDonatableShares *decimal.Decimal `json:"maxDonatableShares,omitempty"`
+
+	// the current share price of this lot if it differs from
+	// its asset's price in Input.AssetSharePrices (nil to use that price)
+	SharePrice *decimal.Decimal `json:"sharePrice,omitempty"`
+
+	// the other name of this lot's asset if its name in the input
+	// is an alias (see Input.Aliases): the name in the input
+	// or, after Output.restoreAliasNames, the canonical name
+	alias string
+
+	// whether this lot is cash (like the proceeds of lots already sold)
+	// whose Shares are its amount, whose share price is 1,
+	// and which has no capital gains (ShareCost and SharePrice are ignored)
+	Cash bool `json:"cash,omitempty"`
+
+	// whether Optimize must donate all of this lot's donatable shares
+	// (like a lot chosen by specific identification)
+	// before choosing other lots with what remains of the budget
+	Pinned bool `json:"pinned,omitempty"`
+
+	// the lot's other fields (like account numbers and lot IDs),
+	// which Optimize copies to the output lot unchanged
+	// (see UnmarshalJSON and MarshalJSON)
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Purchase is a recent purchase of an asset,
+// which can make selling the asset's lots at a loss a wash sale.
+type Purchase struct {
+	AssetName string `json:"assetName"`
+	Date      string `json:"date"`
+}
+
+// Charity is a recipient of part of a split donation.
+type Charity struct {
+	Name   string          `json:"name"`
+	Budget decimal.Decimal `json:"budget"`
+}
+
+type Input struct {
+	AssetSharePrices map[string]decimal.Decimal `json:"assetSharePrices"`
+	Lots             []LotJSON                  `json:"lots"`
+
+	// the charities among which to split the donation
+	// (see OptimizeCharities)
+	Charities []Charity `json:"charities,omitempty"`
+
+	// only used when maximizing capital losses
+	RecentPurchases []Purchase `json:"recentPurchases,omitempty"`
+
+	// the donation amount to use if Options.Donation is empty
+	Donation DonationAmount `json:"donation,omitempty"`
+
+	// alternative asset names (keys) for the assets in AssetSharePrices
+	// (values), which Optimize replaces with the latter
+	Aliases map[string]string `json:"aliases,omitempty"`
+
+	// the shares of the lots that were already donated,
+	// which Optimize does not donate again
+	AlreadyDonated []PriorDonation `json:"alreadyDonated,omitempty"`
+}
+
+// DonationAmount is a donation amount like Options.Donation
+// (a decimal or a percentage like "5%"), which can be
+// a JSON number or string.
+type DonationAmount string
+
+func (d *DonationAmount) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*d = DonationAmount(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf(`donation must be a number or string: %s`, data)
+	}
+	*d = DonationAmount(n)
+	return nil
+}
+
+// SharePrice returns the current share price of lot:
+// 1 if it is cash, its own sharePrice if it has one,
+// or else its asset's price.
+func (i *Input) SharePrice(lot *LotJSON) decimal.Decimal {
+	if lot.Cash {
+		return decimal.NewFromInt(1)
+	}
+	if lot.SharePrice != nil {
+		return *lot.SharePrice
+	}
+	return i.AssetSharePrices[i.CanonicalName(lot.AssetName)]
+}
+
+func (i *Input) UnitCapitalGains(lot *LotJSON) decimal.Decimal {
+	if lot.Cash {
+		return decimal.Zero
+	}
+	return i.SharePrice(lot).Sub(lot.ShareCost)
+}
+
+// MergeInputs concatenates the lots, charities, recent purchases,
+// and prior donations of inputs
+// and merges their share prices.
+// It returns an error if two inputs have different prices for the same asset.
+func MergeInputs(inputs []Input) (merged Input, err error) {
+	merged.AssetSharePrices = make(map[string]decimal.Decimal)
+	for _, input := range inputs {
+		for _, name := range input.assetNames() {
+			price := input.AssetSharePrices[name]
+			if mergedPrice, ok := merged.AssetSharePrices[name]; ok && !mergedPrice.Equal(price) {
+				err = fmt.Errorf(`inputs have different share prices for %s: %s and %s`, name, mergedPrice, price)
+				return
+			}
+			merged.AssetSharePrices[name] = price
+		}
+		merged.Lots = append(merged.Lots, input.Lots...)
+		merged.Charities = append(merged.Charities, input.Charities...)
+		merged.RecentPurchases = append(merged.RecentPurchases, input.RecentPurchases...)
+		merged.AlreadyDonated = append(merged.AlreadyDonated, input.AlreadyDonated...)
+		for alias, canonical := range input.Aliases {
+			if mergedCanonical, ok := merged.Aliases[alias]; ok && mergedCanonical != canonical {
+				err = fmt.Errorf(`inputs have different canonical names for alias %s: %s and %s`, alias, mergedCanonical, canonical)
+				return
+			}
+			if merged.Aliases == nil {
+				merged.Aliases = make(map[string]string)
+			}
+			merged.Aliases[alias] = canonical
+		}
+		if input.Donation != "" {
+			if merged.Donation != "" && merged.Donation != input.Donation {
+				err = fmt.Errorf(`inputs have different donation amounts: %s and %s`, merged.Donation, input.Donation)
+				return
+			}
+			merged.Donation = input.Donation
+		}
+	}
+	return
+}
+
+// lotKey identifies duplicate lots: lots with the same asset name,
+// date string, and share cost.
+type lotKey struct {
+	assetName string
+	date      string
+	shareCost string
+}
+
+func getLotKey(lot *LotJSON) lotKey {
+	return lotKey{lot.AssetName, lot.Date, lot.ShareCost.String()}
+}
+
+// CheckDuplicateLots returns an error naming the first lot in lots
+// with the same asset name, date, and share cost as an earlier lot.
+func CheckDuplicateLots(lots []LotJSON) error {
+	indexes := make(map[lotKey]int)
+	for m := range lots {
+		key := getLotKey(&lots[m])
+		if index, ok := indexes[key]; ok {
+			return fmt.Errorf(`lots[%d] duplicates lots[%d]: lot of %s acquired on %s with share cost %s`, m, index, key.assetName, key.date, key.shareCost)
+		}
+		indexes[key] = m
+	}
+	return nil
+}
+
+// MergeDuplicateLots returns lots with each group of lots
+// with the same asset name, date, and share cost merged into one lot
+// (at the position of the group's first lot) whose shares are the sum
+// of the group's shares.
+// The merged lot has no maxDonatableShares if any lot in its group has none.
+// Lots whose dates are written differently (like 2019-01-02
+// and 2019-01-02T00:00:00Z) are not merged.
+func MergeDuplicateLots(lots []LotJSON) (merged []LotJSON) {
+	indexes := make(map[lotKey]int)
+	for m := range lots {
+		key := getLotKey(&lots[m])
+		index, ok := indexes[key]
+		if !ok {
+			indexes[key] = len(merged)
+			merged = append(merged, lots[m])
+			continue
+		}
+		lot := &merged[index]
+		lot.Shares = lot.Shares.Add(lots[m].Shares)
+		if lot.MaxDonatableShares != nil && lots[m].MaxDonatableShares != nil {
+			maxDonatableShares := lot.MaxDonatableShares.Add(*lots[m].MaxDonatableShares)
+			lot.MaxDonatableShares = &maxDonatableShares
+		} else {
+			lot.MaxDonatableShares = nil
+		}
+	}
+	return
+}
+
+// assetNames returns the sorted names of the assets in i.AssetSharePrices,
+// in whose order validation checks their prices
+// so that it reports the same error on every run.
+func (i *Input) assetNames() (names []string) {
+	for name := range i.AssetSharePrices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// UnusedAssets returns the sorted names of the assets
+// in i.AssetSharePrices that no lot in i has.
+func (i *Input) UnusedAssets() (unused []string) {
+	used := make(map[string]bool, len(i.AssetSharePrices))
+	for _, lot := range i.Lots {
+		used[i.CanonicalName(lot.AssetName)] = true
+	}
+	for name := range i.AssetSharePrices {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return
+}
+
+// GetTotalValue returns the total value of all lots at their current prices.
+func (i *Input) GetTotalValue() (totalValue decimal.Decimal) {
+	for _, lot := range i.Lots {
+		totalValue = totalValue.Add(i.SharePrice(&lot).Mul(lot.Shares))
+	}
+	return
+}
+
+type OutputLot struct {
+	LotJSON
+	LongTerm bool `json:"longTerm"`
+
+	// number of shares that the lot has in the input
+	OriginalShares decimal.Decimal `json:"originalShares"`
+
+	// capital gains (or losses if negative) of the donated shares,
+	// which add up to the donation's TotalCapitalGains
+	CapitalGains decimal.Decimal `json:"capitalGains"`
+
+	// whether the donation has fewer of the lot's shares
+	// than the lot has in the input
+	Partial bool `json:"partial,omitempty"`
+
+	// index of the lot in Input.Lots
+	index int
+}
+
+type OutputExcludedLot struct {
+	LotJSON
+	Reason ExclusionReason `json:"reason"`
+
+	// index of the lot in Input.Lots
+	index int
+}
+
+// AssetSummary is the total donation of an asset across its lots.
+type AssetSummary struct {
+	Shares            decimal.Decimal `json:"shares"`
+	TotalValue        decimal.Decimal `json:"totalValue"`
+	TotalCapitalGains decimal.Decimal `json:"totalCapitalGains"`
+}
+
+// EligibleSummary is the baseline donation of every lot
+// that Optimize could donate, ignoring the donation amount.
+type EligibleSummary struct {
+	Lots              int             `json:"lots"`
+	TotalValue        decimal.Decimal `json:"totalValue"`
+	TotalCapitalGains decimal.Decimal `json:"totalCapitalGains"`
+}
+
+// Output is the donation that Optimize chose and its totals.
+// Its object-valued fields (like AssetSharePrices and AssetSummary)
+// are maps, which encoding/json (and so WriteYAML) always encodes
+// with their keys sorted in byte order, so the encoding of an Output
+// is deterministic.
+// New object-valued fields must also be maps (or have sorted keys).
+type Output struct {
+	Lots              []OutputLot                `json:"donation"`
+	AssetSharePrices  map[string]decimal.Decimal `json:"assetSharePrices"`
+	DonationAmount    decimal.Decimal            `json:"donationAmount"`
+	TotalValue        decimal.Decimal            `json:"totalValue"`
+	TotalCapitalGains decimal.Decimal            `json:"totalCapitalGains"`
+
+	// the parts of TotalCapitalGains in long-term and short-term lots
+	// (only set with Options.TermGains)
+	LongTermGains  *decimal.Decimal `json:"longTermGains,omitempty"`
+	ShortTermGains *decimal.Decimal `json:"shortTermGains,omitempty"`
+
+	RemainingBudget decimal.Decimal `json:"remainingBudget"`
+
+	// the donation amount minus the fee (only set with Options.FeePercent)
+	Budget *decimal.Decimal `json:"budget,omitempty"`
+
+	GainsRatio   decimal.Decimal         `json:"gainsRatio"`
+	AssetSummary map[string]AssetSummary `json:"assetSummary"`
+
+	// the lots that cannot be donated in input order
+	ExcludedLots []OutputExcludedLot `json:"excludedLots,omitempty"`
+
+	// the eligible lots in input order with the shares
+	// (and maxDonatableShares) that the donation leaves them,
+	// omitting the lots that it empties
+	// (only set with Options.RemainingLots)
+	RemainingLots []LotJSON `json:"remainingLots,omitempty"`
+
+	// only set with Options.Summary
+	Eligible *EligibleSummary `json:"eligible,omitempty"`
+
+	// other donations nearly as good as the donation
+	// (only set with Options.Alternatives)
+	Alternatives []Alternative `json:"alternatives,omitempty"`
+
+	// the donation to each charity (only set by OptimizeCharities)
+	Charities []CharityDonation `json:"charities,omitempty"`
+
+	// only set when maximizing capital losses with a loss cap
+	LossCap    *decimal.Decimal `json:"lossCap,omitempty"`
+	ExcessLoss *decimal.Decimal `json:"excessLoss,omitempty"`
+
+	// only set with Options.LTCGRate or Options.StateRate (or Options.IncomeRate
+	// when maximizing capital losses)
+	EstimatedTaxSavings *decimal.Decimal `json:"estimatedTaxSavings,omitempty"`
+
+	// only set with Options.MarginalStep
+	Marginal *Marginal `json:"marginal,omitempty"`
+
+	// the totals of the donation and input's prior donations together
+	// (only set with Options.CombinePriorDonations)
+	Combined *PriorTotals `json:"combined,omitempty"`
+
+	// whether the best donation had more than Options.MaxLots lots,
+	// so that the donation is the best of at most MaxLots lots instead
+	MaxLotsReached bool `json:"maxLotsReached,omitempty"`
+
+	// whether the solver did not finish within Options.Timeout,
+	// so the donation is a greedy one that may not be optimal
+	// (and Alternatives are those found before the deadline)
+	Approximate bool `json:"approximate,omitempty"`
+
+	// whether the donation is the solution of the fractional relaxation
+	// (only set with Options.Fractional), so its lots' shares
+	// may be fractional and its TotalCapitalGains is an upper bound
+	// on those of any donation of whole share units
+	Fractional bool `json:"fractional,omitempty"`
+
+	// the currency of the monetary values and the number of its units
+	// per unit of the input's currency (only set by ConvertCurrency)
+	Currency string           `json:"currency,omitempty"`
+	FXRate   *decimal.Decimal `json:"fxRate,omitempty"`
+
+	Config Config `json:"config"`
+}
+
+// Marginal estimates how much more capital gains (or losses)
+// a slightly larger donation would capture.
+type Marginal struct {
+	// the extra donation amount (Options.MarginalStep)
+	Step decimal.Decimal `json:"step"`
+
+	// the total capital gains of the best donation
+	// of Output.DonationAmount plus Step
+	TotalCapitalGains decimal.Decimal `json:"totalCapitalGains"`
+
+	// the difference between TotalCapitalGains
+	// and Output.TotalCapitalGains per unit of Step
+	// (negative for additional losses)
+	GainsPerDollar decimal.Decimal `json:"gainsPerDollar"`
+
+	// whether the larger donation is a greedy one
+	// because its solver did not finish within Options.Timeout
+	Approximate bool `json:"approximate,omitempty"`
+}
+
+// WashSaleDays is the number of days before or after a sale
+// within which buying the same asset makes the sale a wash sale.
+const WashSaleDays = 30
+
+// IsWashSale reports whether a purchase on the specified date
+// makes selling its asset at a loss on saleDate a wash sale
+// (because it is at most WashSaleDays calendar days before or after saleDate).
+func IsWashSale(purchased time.Time, saleDate time.Time) bool {
+	days := HeldDays(purchased, saleDate)
+	return -WashSaleDays <= days && days <= WashSaleDays
+}
+
+// Config is the effective configuration that produced an Output
+// (with defaults and percentages resolved) so that stored outputs
+// describe how they were produced.
+type Config struct {
+	// the donation amount (see Output.DonationAmount)
+	// and Options.Donation (unless splitting among charities)
+	DonationAmount decimal.Decimal `json:"donationAmount"`
+	Donation       string          `json:"donation,omitempty"`
+
+	// only set with Options.FeePercent
+	FeePercent *decimal.Decimal `json:"feePercent,omitempty"`
+
+	MaximizeLosses bool `json:"maximizeLosses"`
+
+	// only set if they are not zero
+	LTCGRate   *decimal.Decimal `json:"ltcgRate,omitempty"`
+	StateRate  *decimal.Decimal `json:"stateRate,omitempty"`
+	IncomeRate *decimal.Decimal `json:"incomeRate,omitempty"`
+
+	// only set when maximizing capital losses
+	LossCap  *decimal.Decimal `json:"lossCap,omitempty"`
+	SaleDate string           `json:"saleDate,omitempty"`
+
+	// only set if it is not zero
+	MinLotGain *decimal.Decimal `json:"minLotGain,omitempty"`
+	PerLotFee  *decimal.Decimal `json:"perLotFee,omitempty"`
+
+	// only set if it is positive
+	MaxLots   int              `json:"maxLots,omitempty"`
+	AgeWeight *decimal.Decimal `json:"ageWeight,omitempty"`
+
+	// only set with Options.ScaleDecimals
+	ScaleDecimals *int32 `json:"scaleDecimals,omitempty"`
+
+	// only set with Options.IntegerCents
+	IntegerCents bool `json:"integerCents,omitempty"`
+
+	AsOf             string `json:"asOf"`
+	LongTermDays     int    `json:"longTermDays"`
+	IncludeShortTerm bool   `json:"includeShortTerm"`
+	CashFirst        bool   `json:"cashFirst"`
+	Target           string `json:"target"`
+	Objective        string `json:"objective"`
+
+	// only set when Target is TargetExact
+	Tolerance *decimal.Decimal `json:"tolerance,omitempty"`
+
+	ExcludeAssets    []string `json:"excludeAssets,omitempty"`
+	OnlyAssets       []string `json:"onlyAssets,omitempty"`
+	MinimizeLots     bool     `json:"minimizeLots"`
+	WholeLots        bool     `json:"wholeLots"`
+	Fractional       bool     `json:"fractional,omitempty"`
+	BasisMethod      string   `json:"basisMethod"`
+	TieBreak         string   `json:"tieBreak"`
+	Sort             bool     `json:"sort"`
+	Strict           bool     `json:"strict"`
+	RejectDuplicates bool     `json:"rejectDuplicates"`
+	MergeDuplicates  bool     `json:"mergeDuplicates"`
+}
+
+// newConfig returns the Config of an Output
+// that Optimize produced from opts (with AsOf and SaleDate set).
+func newConfig(opts *Options, donationAmount decimal.Decimal) (config Config) {
+	config = Config{
+		DonationAmount:   donationAmount,
+		Donation:         opts.Donation,
+		MaximizeLosses:   opts.MaximizeLosses,
+		AsOf:             opts.AsOf.Format(dateLayouts[0]),
+		LongTermDays:     opts.LongTermDays,
+		IncludeShortTerm: opts.IncludeShortTerm,
+		CashFirst:        opts.CashFirst,
+		Target:           opts.Target,
+		Objective:        opts.Objective,
+		ExcludeAssets:    opts.ExcludeAssets,
+		OnlyAssets:       opts.OnlyAssets,
+		MinimizeLots:     opts.MinimizeLots,
+		WholeLots:        opts.WholeLots,
+		Fractional:       opts.Fractional,
+		BasisMethod:      opts.BasisMethod,
+		TieBreak:         opts.TieBreak,
+		Sort:             opts.Sort,
+		Strict:           opts.Strict,
+		RejectDuplicates: opts.RejectDuplicates,
+		MergeDuplicates:  opts.MergeDuplicates}
+	if opts.MaximizeLosses {
+		lossCap := opts.LossCap
+		config.LossCap = &lossCap
+		config.SaleDate = opts.SaleDate.Format(dateLayouts[0])
+	}
+	if !opts.FeePercent.IsZero() {
+		feePercent := opts.FeePercent
+		config.FeePercent = &feePercent
+	}
+	if opts.ScaleDecimals != nil {
+		scale := *opts.ScaleDecimals
+		config.ScaleDecimals = &scale
+	}
+	config.IntegerCents = opts.IntegerCents
+	if !opts.LTCGRate.IsZero() {
+		ltcgRate := opts.LTCGRate
+		config.LTCGRate = &ltcgRate
+	}
+	if !opts.StateRate.IsZero() {
+		stateRate := opts.StateRate
+		config.StateRate = &stateRate
+	}
+	if !opts.IncomeRate.IsZero() {
+		incomeRate := opts.IncomeRate
+		config.IncomeRate = &incomeRate
+	}
+	if !opts.MinLotGain.IsZero() {
+		minLotGain := opts.MinLotGain
+		config.MinLotGain = &minLotGain
+	}
+	if !opts.PerLotFee.IsZero() {
+		perLotFee := opts.PerLotFee
+		config.PerLotFee = &perLotFee
+	}
+	if opts.MaxLots > 0 {
+		config.MaxLots = opts.MaxLots
+	}
+	if opts.AgeWeight.IsPositive() {
+		ageWeight := opts.AgeWeight
+		config.AgeWeight = &ageWeight
+	}
+	if config.Target == "" {
+		config.Target = TargetGains
+	}
+	if config.Objective == "" {
+		config.Objective = ObjectiveGains
+	}
+	if config.TieBreak == "" {
+		config.TieBreak = DefaultTieBreak
+	}
+	if config.Target == TargetExact {
+		tolerance := opts.Tolerance
+		config.Tolerance = &tolerance
+	}
+	return
+}
+
+// Donation targets for Options.Target
+const (
+	// Maximize capital gains (or losses) without exceeding the donation amount.
+	TargetGains = "gains"
+
+	// Get as close to the donation amount as possible without exceeding it
+	// and then maximize capital gains (or losses).
+	TargetExact = "exact"
+)
+
+// Solvers for Options.Solver
+const (
+	// Solve 0-1 knapsack problems with knapsack.Get01Solution
+	// (or Parallel01Solution).
+	SolverKnapsack = "knapsack"
+
+	// Solve 0-1 knapsack problems with Rolling01Solution,
+	// which makes far fewer allocations and uses somewhat less memory.
+	SolverRolling = "rolling"
+)
+
+// Objectives for Options.Objective
+const (
+	// Maximize the total capital gains (or losses).
+	ObjectiveGains = "gains"
+
+	// Maximize the total capital gains (or losses)
+	// and then minimize the total value of the donation
+	// (maximizing the gains per dollar donated).
+	ObjectiveEfficiency = "efficiency"
+)
+
+// Options controls how Optimize chooses a donation.
+type Options struct {
+	// Donation is the donation amount, which must be a positive decimal,
+	// or a percentage (like "5%") of the total value of all lots.
+	// If it is empty, Optimize uses the input's donation amount.
+	Donation string
+
+	// MaximizeLosses makes Optimize maximize capital losses
+	// instead of capital gains.
+	MaximizeLosses bool
+
+	// LossCap is the capital loss beyond which Optimize stops
+	// adding losing shares when MaximizeLosses is set
+	// (zero for no cap).
+	LossCap decimal.Decimal
+
+	// ExcludeAssets are the names of assets whose lots Optimize
+	// must not donate.
+	ExcludeAssets []string
+
+	// OnlyAssets, if it is not nil, are the names of the only assets
+	// whose lots Optimize may donate (unless they are in ExcludeAssets).
+	// An empty non-nil slice makes no lots eligible.
+	OnlyAssets []string
+
+	// Strict makes Optimize return an error
+	// if input.AssetSharePrices has assets that no lot has
+	// (see Input.UnusedAssets).
+	Strict bool
+
+	// RejectDuplicates makes Optimize return an error
+	// if two lots have the same asset name, date, and share cost
+	// (see CheckDuplicateLots).
+	RejectDuplicates bool
+
+	// MergeDuplicates makes Optimize merge lots
+	// with the same asset name, date, and share cost
+	// before choosing a donation (see MergeDuplicateLots).
+	MergeDuplicates bool
+
+	// LTCGRate is the long-term capital gains tax rate (like 0.15)
+	// with which Optimize estimates the tax that donating capital gains
+	// avoids (zero, with a zero StateRate, for no estimate;
+	// see Output.EstimatedTaxSavings).
+	LTCGRate decimal.Decimal
+
+	// StateRate is the state capital gains tax rate (like 0.05)
+	// that Optimize adds to LTCGRate for the estimate.
+	StateRate decimal.Decimal
+
+	// IncomeRate is the ordinary income tax rate (like 0.24)
+	// with which Optimize estimates the tax that deducting capital losses
+	// saves when MaximizeLosses is set (zero for no estimate).
+	IncomeRate decimal.Decimal
+
+	// AsOf is the date against which holding periods are computed
+	// (the zero value means today).
+	AsOf time.Time
+
+	// SaleDate is the date on which the lots will be sold
+	// when MaximizeLosses is set (the zero value means AsOf).
+	// Optimize excludes the lots of assets with recent purchases
+	// within WashSaleDays days of SaleDate (see IsWashSale).
+	SaleDate time.Time
+
+	// LongTermDays is the number of calendar days a lot must be held
+	// to be long-term (see IsLongTerm).
+	// Zero means more than one year.
+	LongTermDays int
+
+	// IncludeShortTerm makes Optimize consider lots that are not
+	// long-term when maximizing capital gains.
+	IncludeShortTerm bool
+
+	// CashFirst makes Optimize donate cash lots (see LotJSON.Cash)
+	// before choosing other lots with what remains of the budget
+	// instead of filling what the other lots leave with cash.
+	CashFirst bool
+
+	// MinLotGain is the smallest total capital gain
+	// (or loss when MaximizeLosses is set) of a lot's donatable shares
+	// for Optimize to consider the lot (zero for no minimum).
+	MinLotGain decimal.Decimal
+
+	// PerLotFee is a fee for each distinct lot other than cash
+	// in the donation, which Optimize subtracts from the total
+	// capital gains (or losses) that it maximizes
+	// so that it only donates another lot if the lot is worth the fee
+	// (zero for no fee).  It does not reduce the budget.
+	PerLotFee decimal.Decimal
+
+	// AgeWeight, if it is positive, favors older lots
+	// among donations with similar capital gains (or losses):
+	// Optimize maximizes the capital gains (or losses) of each lot
+	// times 1 plus AgeWeight for each year (of 365 days)
+	// that the lot has been held as of AsOf.
+	// For example, with an AgeWeight of 0.001, a lot held for ten years
+	// beats a lot held for one year with up to 0.9% more gains
+	// but never one with more.
+	// Zero weights every lot equally.
+	AgeWeight decimal.Decimal
+
+	// Sort makes Optimize sort the donation lots (see SortLots).
+	Sort bool
+
+	// KeepAliasNames makes the output's lots keep the asset names
+	// that they have in the input instead of the canonical names
+	// of their aliases (see Input.Aliases).
+	KeepAliasNames bool
+
+	// MaxCells is the maximum number of knapsack cells
+	// (items or share units times normalized donation; see CheckCells)
+	// that Optimize will allocate
+	// (zero for no limit).
+	MaxCells uint64
+
+	// MaxItems is the maximum number of knapsack items
+	// (the lots' items from SplitLots or, for the solvers that solve by lot,
+	// lots; see CheckItems) that Optimize will allocate
+	// (zero for no limit).
+	MaxItems uint64
+
+	// MaxPricePrecision is the maximum number of decimal places
+	// of the share prices and costs (zero for no limit),
+	// which guards against prices that are so precise
+	// that the knapsack table is too large (see NewNormalizedLots).
+	MaxPricePrecision int32
+
+	// MaxAmount and MaxShares are the greatest share price, cost,
+	// cash amount, or donation amount and the greatest number of shares
+	// of a lot other than cash (zero for no limit), beyond which
+	// NewNormalizedLots fails, which catches mistyped inputs.
+	MaxAmount decimal.Decimal
+	MaxShares decimal.Decimal
+
+	// ScaleDecimals, if it is not nil, is the number of decimal places
+	// to which Optimize rounds the share prices, costs, cash amounts,
+	// and donation amount before solving (rounding cash and the donation down)
+	// instead of using the precision of the most precise of them,
+	// which trades exactness for a smaller knapsack table.
+	// The output has the rounded prices and costs.
+	ScaleDecimals *int32
+
+	// IntegerCents means that the share prices, costs, cash amounts,
+	// and donation amount are whole numbers of cents,
+	// so NewNormalizedLots uses them as they are
+	// instead of deriving the exponent of share prices
+	// (and fails if any of them is not an integer).
+	// The output's monetary values are then in cents too
+	// (see Output.CentsToDollars), as are the other options' amounts.
+	IntegerCents bool
+
+	// WholeLots makes Optimize donate all of a lot's donatable shares
+	// or none of them, so that it never splits a lot.
+	WholeLots bool
+
+	// MinimizeLots makes Optimize choose, among the donations
+	// with the greatest capital gains (or losses),
+	// one with the fewest distinct lots.
+	MinimizeLots bool
+
+	// Fractional makes Optimize donate the solution
+	// of the fractional relaxation of the problem (see FractionalSolution),
+	// which may donate a fraction of a lot's last share unit,
+	// instead of solving a knapsack problem, setting Output.Fractional.
+	// It is fast, and its capital gains (or losses)
+	// are an upper bound on those of any donation of whole share units
+	// (when Objective is not ObjectiveEfficiency
+	// and neither AgeWeight nor ValueFunc is set).
+	// It is incompatible with WholeLots, MinimizeLots, TargetExact,
+	// PerLotFee, Alternatives, MaxLots, and charities.
+	Fractional bool
+
+	// FeePercent is the percentage of the donation amount
+	// that a donor-advised fund charges as a fee,
+	// which Optimize subtracts from the donation amount
+	// to get the budget for the donated lots' total value.
+	FeePercent decimal.Decimal
+
+	// Target is TargetGains or TargetExact.
+	// The empty string means TargetGains.
+	Target string
+
+	// Tolerance is, when Target is TargetExact, how far below
+	// the closest achievable total value to the donation amount
+	// the donation's total value may be
+	// in exchange for greater capital gains (or losses).
+	Tolerance decimal.Decimal
+
+	// Objective is ObjectiveGains or ObjectiveEfficiency.
+	// The empty string means ObjectiveGains.
+	Objective string
+
+	// BasisMethod is the cost basis method (FIFO, LIFO, HIFO, or LOCO)
+	// that breaks ties between equally valuable donations
+	// (empty to prefer lots that appear earlier in the input).
+	BasisMethod string
+
+	// TieBreak is TieBreakInput, TieBreakMaxGain, or TieBreakMaxAge,
+	// which breaks the ties that BasisMethod leaves.
+	// The empty string means DefaultTieBreak (TieBreakMaxGain).
+	TieBreak string
+
+	// Parallel is the number of goroutines that fill the knapsack table
+	// of large problems (see Parallel01Solution).
+	// It does not affect MinimizeLots or TargetExact.
+	// Zero or one means Optimize uses knapsack.Get01Solution.
+	Parallel int
+
+	// Solver is SolverKnapsack or SolverRolling, which ignores Parallel.
+	// The empty string means SolverKnapsack.
+	// It does not affect MinimizeLots or TargetExact,
+	// and both solvers choose the same donation.
+	Solver string
+
+	// Alternatives is the maximum number of alternative donations
+	// (see FindAlternatives) to put in Output.Alternatives.
+	Alternatives int
+
+	// AlternativesTolerance is how much less capital gains (or losses)
+	// an alternative donation may have than the donation.
+	AlternativesTolerance decimal.Decimal
+
+	// Summary makes Optimize set Output.Eligible.
+	Summary bool
+
+	// CombinePriorDonations makes Optimize set Output.Combined.
+	CombinePriorDonations bool
+
+	// MaxLots, if it is positive, is the maximum number of distinct lots
+	// (including cash and pinned lots) in the donation and each alternative.
+	// If the best donation has more, Optimize solves again
+	// for the best donation of at most MaxLots lots
+	// (with the solver of MinimizeLots, which takes about MaxLots times
+	// as long and as much memory), setting Output.MaxLotsReached.
+	MaxLots int
+
+	// TermGains makes Optimize set Output.LongTermGains
+	// and Output.ShortTermGains.
+	TermGains bool
+
+	// RemainingLots makes Optimize set Output.RemainingLots.
+	RemainingLots bool
+
+	// Timeout, if it is positive, is how long Optimize lets
+	// its knapsack solvers run, in total, before it stops them
+	// and donates lots that it chooses greedily instead
+	// (see GreedySolution), setting Output.Approximate.
+	// The deadline covers every solve of one call of Optimize
+	// (including those of MaxLots, Alternatives, MarginalStep
+	// and each charity): Alternatives that it stops are left out,
+	// and MarginalStep's donation may be greedy too.
+	Timeout time.Duration
+
+	// Context, if it is not nil, stops the solvers when it is done,
+	// in which case Optimize returns its error.
+	// knapsack.Get01Solution cannot stop early,
+	// so Optimize uses Rolling01Solution instead when Context
+	// or Timeout is set, which chooses the same donation.
+	Context context.Context
+
+	// the context that ends opts.Timeout after Optimize starts
+	// (see startTimeout)
+	timeoutContext context.Context
+
+	// MarginalStep, if it is positive, makes Optimize set Output.Marginal
+	// by also optimizing a donation that is MarginalStep larger
+	// (unless the input has charities).
+	MarginalStep decimal.Decimal
+
+	// Explain receives an explanation of why Optimize did or did not
+	// choose each lot if it is not nil.
+	Explain io.Writer
+
+	// Logger, if it is not nil, receives messages about how Optimize
+	// reaches its decision up to Verbosity (VerbosityInfo or VerbosityDebug).
+	Logger    *log.Logger
+	Verbosity int
+
+	// Progress, if it is not nil, receives the number of knapsack cells
+	// that the solver has filled and the total number of cells
+	// after it fills each item's (or lot's) cells.
+	// knapsack.Get01Solution cannot report its progress,
+	// so Optimize uses Rolling01Solution instead when Progress is set,
+	// which chooses the same donation.
+	// Each knapsack problem (like those for Alternatives
+	// or each charity) starts again from zero.
+	Progress func(done, total uint64)
+
+	// ValueFunc, if it is not nil, replaces the knapsack value
+	// that Optimize maximizes for one share unit of each eligible lot:
+	// it receives the lot and its default value,
+	// which is its unit capital gains (or losses if MaximizeLosses is set)
+	// as an integer in units of the normalized prices
+	// (10^-k of the input's prices, where k is the number of decimal places
+	// that normalization needs, including those of fractional shares),
+	// and returns the lot's value in the same units.
+	// It must not return a negative value,
+	// should return the same value for the same lot every time
+	// (Optimize calls it many times),
+	// and must keep the total value of all lots within an int64.
+	// Filtering (like excluding lots without gains) and the output's totals
+	// still use the lots' capital gains.
+	ValueFunc func(lot *LotJSON, value int64) int64
+}
+
+// Optimize chooses the lots in input to donate.
+// It never chooses lots whose total value exceeds opts.Donation.
+// If input has charities, it calls OptimizeCharities instead.
+func Optimize(input Input, opts Options) (output Output, err error) {
+	defer opts.startTimeout()()
+	if len(input.Charities) > 0 {
+		return OptimizeCharities(input, opts)
+	}
+	normalizedLots, prior, err := prepare(&input, &opts)
+	if err != nil {
+		return
+	}
+	normalizedLots.ctx = opts.solverContext()
+	if opts.Summary {
+		output.Eligible = &EligibleSummary{Lots: len(normalizedLots.lots)}
+		for _, lot := range normalizedLots.lots {
+			shares := normalizedLots.LotShares(&lot)
+			output.Eligible.TotalValue = output.Eligible.TotalValue.Add(input.SharePrice(lot.json).Mul(shares))
+			output.Eligible.TotalCapitalGains = output.Eligible.TotalCapitalGains.Add(input.UnitCapitalGains(lot.json).Mul(shares))
+		}
+	}
+
+	// Calculate the optimal donation.
+	donationLots, approximate, err := normalizedLots.chooseLotsWithin(&opts)
+	if err != nil {
+		return
+	}
+	maxLotsReached := opts.MaxLots > 0 && len(donationLots) > opts.MaxLots
+	if maxLotsReached {
+		opts.logf(VerbosityInfo, "solver chose %d lots, more than the maximum of %d, so solving again with at most %d", len(donationLots), opts.MaxLots, opts.MaxLots)
+		var capApproximate bool
+		if donationLots, capApproximate, err = normalizedLots.capLots(&opts); err != nil {
+			return
+		}
+		approximate = approximate || capApproximate
+	}
+	opts.logf(VerbosityInfo, "solver chose %d lots with a total normalized value of %d", len(donationLots), normalizedLots.totalValue(donationLots))
+	var alternatives [][]Lot
+	if opts.Alternatives > 0 && !approximate {
+		if alternatives, err = normalizedLots.FindAlternatives(&opts, donationLots); err != nil {
+			return
+		}
+	}
+	useLossCap := opts.MaximizeLosses && opts.LossCap.IsPositive()
+
+	if opts.Sort {
+		SortLots(donationLots)
+		for _, alternative := range alternatives {
+			SortLots(alternative)
+		}
+	}
+	if opts.Explain != nil {
+		explain(opts.Explain, &input, &normalizedLots, donationLots)
+	}
+
+	// Compute the totals of the optimal donation.
+	output = Output{
+		Lots:             normalizedLots.newOutputLots(donationLots),
+		AssetSharePrices: input.AssetSharePrices,
+		DonationAmount:   normalizedLots.donationAmount,
+		Eligible:         output.Eligible,
+		MaxLotsReached:   maxLotsReached,
+		Approximate:      approximate,
+		Fractional:       opts.Fractional,
+		Config:           newConfig(&opts, normalizedLots.donationAmount)}
+	output.TotalValue, output.TotalCapitalGains, output.AssetSummary = summarizeLots(&input, output.Lots)
+	output.RemainingBudget = normalizedLots.budget.Sub(output.TotalValue)
+	for _, lots := range alternatives {
+		alternative := Alternative{Lots: normalizedLots.newOutputLots(lots)}
+		alternative.TotalValue, alternative.TotalCapitalGains, alternative.AssetSummary = summarizeLots(&input, alternative.Lots)
+		alternative.RemainingBudget = normalizedLots.budget.Sub(alternative.TotalValue)
+		output.Alternatives = append(output.Alternatives, alternative)
+	}
+	if !opts.FeePercent.IsZero() {
+		output.Budget = &normalizedLots.budget
+	}
+	for _, lot := range normalizedLots.excluded {
+		output.ExcludedLots = append(output.ExcludedLots, OutputExcludedLot{LotJSON: *lot.json, Reason: lot.Reason, index: lot.index})
+	}
+	if opts.RemainingLots {
+		output.RemainingLots = normalizedLots.remainingLots(donationLots)
+	}
+	if !output.TotalValue.IsZero() {
+		output.GainsRatio = output.TotalCapitalGains.Div(output.TotalValue)
+	}
+	if useLossCap {
+		lossCap := opts.LossCap
+		excessLoss := decimal.Max(output.TotalCapitalGains.Neg().Sub(lossCap), decimal.Zero)
+		output.LossCap = &lossCap
+		output.ExcessLoss = &excessLoss
+	}
+	if opts.CombinePriorDonations {
+		output.Combined = &PriorTotals{
+			TotalValue:        prior.TotalValue.Add(output.TotalValue),
+			TotalCapitalGains: prior.TotalCapitalGains.Add(output.TotalCapitalGains)}
+	}
+	if opts.TermGains {
+		splitGainsByTerm(&input, &output)
+	}
+	estimateTaxSavings(&output, &opts)
+	if opts.MarginalStep.IsPositive() {
+		err = addMarginal(&output, input, opts)
+	}
+	if opts.KeepAliasNames {
+		output.restoreAliasNames()
+	}
+	return
+}
+
+// addMarginal sets output.Marginal by optimizing a donation
+// opts.MarginalStep larger than output's for input.
+func addMarginal(output *Output, input Input, opts Options) error {
+	step := opts.MarginalStep
+	opts.Donation = output.DonationAmount.Add(step).String()
+	opts.MarginalStep = decimal.Zero
+	opts.Alternatives = 0
+	opts.Summary = false
+	opts.Explain = nil
+	larger, err := Optimize(input, opts)
+	if err != nil {
+		return fmt.Errorf(`marginal donation: %w`, err)
+	}
+	output.Marginal = &Marginal{
+		Step:              step,
+		TotalCapitalGains: larger.TotalCapitalGains,
+		GainsPerDollar:    larger.TotalCapitalGains.Sub(output.TotalCapitalGains).Div(step),
+		Approximate:       larger.Approximate}
+	return nil
+}
+
+// startTimeout sets opts.timeoutContext to a context that ends
+// opts.Timeout from now (or when opts.Context does)
+// if opts.Timeout is positive and it is not set yet,
+// so that every solve of one call of Optimize shares one deadline,
+// and returns the function that releases the context.
+func (opts *Options) startTimeout() context.CancelFunc {
+	if opts.Timeout <= 0 || opts.timeoutContext != nil {
+		return func() {}
+	}
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	var cancel context.CancelFunc
+	opts.timeoutContext, cancel = context.WithTimeout(parent, opts.Timeout)
+	return cancel
+}
+
+// solverContext returns the context whose end stops the solvers for opts
+// (nil if there is no Context or Timeout).
+func (opts *Options) solverContext() context.Context {
+	if opts.timeoutContext != nil {
+		return opts.timeoutContext
+	}
+	return opts.Context
+}
+
+// checkUnusedAssets returns an error if opts.Strict is set
+// and input has unused assets.
+func checkUnusedAssets(input *Input, opts *Options) error {
+	if unused := input.UnusedAssets(); opts.Strict && len(unused) > 0 {
+		return fmt.Errorf(`assetSharePrices has a price for %s, which no lot has`, unused[0])
+	}
+	return nil
+}
+
+// chooseLots chooses the lots of nl to donate with the solver for opts
+// and with the shares of the lots set to the numbers of share units
+// to donate.
+// Pinned lots come first, and the other lots share what they leave.
+// Cash lots, which have no value to the solvers, fill the budget
+// that the other lots leave (or, with opts.CashFirst, the budget
+// for the other lots is what the cash leaves).
+func (nl *NormalizedLots) chooseLots(opts *Options) (donationLots []Lot, err error) {
+	if pinnedLots, unpinnedLots := PartitionPinnedLots(nl.lots); len(pinnedLots) > 0 {
+		lots, donation, maxLots := nl.lots, nl.donation, nl.maxLots
+		defer func() {
+			nl.lots, nl.donation, nl.maxLots = lots, donation, maxLots
+		}()
+		nl.lots = pinnedLots
+		pinnedPrice, priceErr := nl.GetTotalPrice()
+		if priceErr != nil {
+			return nil, priceErr
+		}
+		if pinnedPrice > nl.donation {
+			err = fmt.Errorf(`pinned lots' total value of %s exceeds the budget of %s`, decimal.New(int64(pinnedPrice), nl.sharePriceExponent+nl.shareExponent), nl.budget)
+			return
+		}
+		nl.lots, nl.donation = unpinnedLots, nl.donation-pinnedPrice
+		if maxLots > 0 {
+			if len(pinnedLots) >= maxLots {
+				return pinnedLots, nil
+			}
+			nl.maxLots -= len(pinnedLots)
+		}
+		if donationLots, err = nl.chooseLots(opts); err != nil {
+			return
+		}
+		return append(pinnedLots, donationLots...), nil
+	}
+	cashLots, otherLots := PartitionCashLots(nl.lots)
+	if len(cashLots) == 0 {
+		return nl.chooseAssetLots(opts)
+	}
+	lots, donation, maxLots := nl.lots, nl.donation, nl.maxLots
+	defer func() {
+		nl.lots, nl.donation, nl.maxLots = lots, donation, maxLots
+	}()
+	var cash []Lot
+	if opts.CashFirst {
+		cash, nl.donation = FillWithCash(cashLots, nl.donation, nl.wholeLots)
+		if maxLots > 0 {
+			if len(cash) >= maxLots {
+				return cash[:maxLots], nil
+			}
+			nl.maxLots -= len(cash)
+		}
+	}
+	nl.lots = otherLots
+	if donationLots, err = nl.chooseAssetLots(opts); err != nil {
+		return
+	}
+	if opts.CashFirst {
+		return append(cash, donationLots...), nil
+	}
+	remaining := nl.donation
+	for m := range donationLots {
+		remaining -= nl.ItemWeight(&donationLots[m])
+		if donationLots[m].fraction.IsPositive() {
+			// The fraction fills the rest of the donation.
+			remaining = 0
+			break
+		}
+	}
+	cash, _ = FillWithCash(cashLots, remaining, nl.wholeLots)
+	if maxLots > 0 && len(donationLots)+len(cash) > maxLots {
+		cash = cash[:maxLots-len(donationLots)]
+	}
+	return append(donationLots, cash...), nil
+}
+
+// chooseAssetLots is chooseLots for lots that are not cash.
+func (nl *NormalizedLots) chooseAssetLots(opts *Options) (donationLots []Lot, err error) {
+	totalPrice, err := nl.GetTotalPrice()
+	if err != nil {
+		return
+	}
+	if err = nl.checkScores(); err != nil {
+		return
+	}
+	start := time.Now()
+	if totalPrice <= nl.donation && nl.lotFee == 0 && (nl.maxLots <= 0 || len(nl.lots) <= nl.maxLots) {
+		opts.logf(VerbosityDebug, "total normalized price %d fits in the capacity, so donating every lot", totalPrice)
+		donationLots = nl.lots
+	} else if nl.fractional {
+		opts.logf(VerbosityDebug, "choosing fractional lots greedily: %d lots, capacity %d", len(nl.lots), nl.donation)
+		donationLots = nl.FractionalSolution()
+	} else if nl.greedy {
+		opts.logf(VerbosityDebug, "choosing lots greedily: %d lots, capacity %d", len(nl.lots), nl.donation)
+		donationLots = nl.GreedySolution()
+	} else if err = nl.checkObjective(); err != nil {
+		return
+	} else if opts.Target == TargetExact || opts.MinimizeLots || nl.lotFee > 0 || nl.maxLots > 0 {
+		if err = CheckItems(uint64(len(nl.lots)), opts.MaxItems); err != nil {
+			return
+		}
+		hi, cells := bits.Mul64(nl.GetShareUnits(), nl.lotLayers())
+		if hi != 0 {
+			cells = math.MaxUint64
+		}
+		if err = nl.CheckCells(cells, opts.MaxCells); err != nil {
+			return
+		}
+		opts.logf(VerbosityDebug, "solving by lot: %d lots, %d share units, %d layers, capacity %d", len(nl.lots), nl.GetShareUnits(), nl.lotLayers(), nl.donation)
+		if opts.Target == TargetExact {
+			tolerance, _ := ShiftToInteger(opts.Tolerance, nl.sharePriceExponent+nl.shareExponent)
+			donationLots = nl.ExactSolution(tolerance)
+		} else {
+			donationLots = nl.MinimizeLotsSolution()
+		}
+		if nl.canceled() {
+			return nil, nl.ctx.Err()
+		}
+	} else {
+		// Lots with zero prices are free to donate
+		// (and knapsack.Get01Solution cannot handle items without weights).
+		freeLots, pricedLots := PartitionFreeLots(nl.lots)
+		items := pricedLots
+		if nl.wholeLots {
+			err = CheckItems(uint64(len(items)), opts.MaxItems)
+		} else if err = CheckItems(countSplitItems(pricedLots), opts.MaxItems); err == nil {
+			items = SplitLots(pricedLots)
+		}
+		if err != nil {
+			return
+		}
+		if err = nl.CheckCells(uint64(len(items)), opts.MaxCells); err != nil {
+			return
+		}
+		opts.logf(VerbosityDebug, "solving 0-1 knapsack: %d free lots, %d items from %d lots, capacity %d", len(freeLots), len(items), len(pricedLots), nl.donation)
+		// knapsack.Get01Solution cannot stop early either,
+		// so a context also makes Rolling01Solution the default.
+		if opts.Solver == SolverRolling || ((opts.Progress != nil || nl.ctx != nil) && opts.Parallel <= 1) {
+			donationLots = nl.Rolling01Solution(items)
+		} else if opts.Parallel > 1 {
+			donationLots = nl.Parallel01Solution(items, opts.Parallel)
+		} else {
+			donationLots = knapsack.Get01Solution(nl.donation, items, nl.ItemWeight, nl.ItemObjective)
+		}
+		if nl.canceled() {
+			return nil, nl.ctx.Err()
+		}
+		donationLots = DeduplicateLots(append(freeLots, donationLots...))
+	}
+	opts.logf(VerbosityDebug, "solved in %v", time.Since(start))
+	if opts.MaximizeLosses && opts.LossCap.IsPositive() {
+		normalizedLossCap, ok := ShiftToInteger(opts.LossCap.Shift(-nl.sharePriceExponent-nl.shareExponent).Ceil(), 0)
+		if !ok {
+			err = fmt.Errorf(`loss cap is too large: %s`, opts.LossCap)
+			return
+		}
+		donationLots = nl.CapLosses(donationLots, normalizedLossCap)
+	}
+	return
+}
+
+// prepare validates input and opts, fills in the defaults of opts,
+// and returns the normalized lots of input, filtered and sorted
+// for solving, and the totals of input's prior donations.
+func prepare(input *Input, opts *Options) (normalizedLots NormalizedLots, prior PriorTotals, err error) {
+	if input.AssetSharePrices == nil {
+		input.AssetSharePrices = make(map[string]decimal.Decimal)
+	}
+	if err = applyAliases(input, opts); err != nil {
+		return
+	}
+	if opts.Donation == "" {
+		opts.Donation = string(input.Donation)
+	}
+	if opts.ScaleDecimals != nil && *opts.ScaleDecimals < 0 {
+		err = fmt.Errorf(`scale must not be negative: %d`, *opts.ScaleDecimals)
+		return
+	}
+	applyScale(input, opts)
+	if opts.AsOf.IsZero() {
+		opts.AsOf = calendarDate(time.Now())
+	}
+	if opts.SaleDate.IsZero() {
+		opts.SaleDate = opts.AsOf
+	}
+	if opts.Target != "" && opts.Target != TargetGains && opts.Target != TargetExact {
+		err = fmt.Errorf(`unknown target: %s`, opts.Target)
+		return
+	}
+	if opts.Solver != "" && opts.Solver != SolverKnapsack && opts.Solver != SolverRolling {
+		err = fmt.Errorf(`unknown solver: %s`, opts.Solver)
+		return
+	}
+	if opts.Objective != "" && opts.Objective != ObjectiveGains && opts.Objective != ObjectiveEfficiency {
+		err = fmt.Errorf(`unknown objective: %s`, opts.Objective)
+		return
+	}
+	if opts.Tolerance.IsNegative() {
+		err = fmt.Errorf(`tolerance must not be negative: %s`, opts.Tolerance)
+		return
+	}
+	if opts.FeePercent.IsNegative() || !opts.FeePercent.LessThan(decimal.NewFromInt(100)) {
+		err = fmt.Errorf(`fee percentage must be at least 0 and less than 100: %s`, opts.FeePercent)
+		return
+	}
+	for _, rate := range []decimal.Decimal{opts.LTCGRate, opts.StateRate, opts.LTCGRate.Add(opts.StateRate), opts.IncomeRate} {
+		if rate.IsNegative() || rate.GreaterThan(decimal.NewFromInt(1)) {
+			err = fmt.Errorf(`tax rates must be between 0 and 1: %s`, rate)
+			return
+		}
+	}
+	if opts.AlternativesTolerance.IsNegative() {
+		err = fmt.Errorf(`alternatives tolerance must not be negative: %s`, opts.AlternativesTolerance)
+		return
+	}
+	if opts.LossCap.IsNegative() {
+		err = fmt.Errorf(`loss cap must not be negative: %s`, opts.LossCap)
+		return
+	}
+	if opts.MarginalStep.IsNegative() {
+		err = fmt.Errorf(`marginal step must not be negative: %s`, opts.MarginalStep)
+		return
+	}
+	if opts.MinLotGain.IsNegative() {
+		err = fmt.Errorf(`minimum lot gain must not be negative: %s`, opts.MinLotGain)
+		return
+	}
+	if opts.MaxLots < 0 {
+		err = fmt.Errorf(`maximum number of lots must not be negative: %d`, opts.MaxLots)
+		return
+	}
+	if opts.PerLotFee.IsNegative() {
+		err = fmt.Errorf(`per-lot fee must not be negative: %s`, opts.PerLotFee)
+		return
+	}
+	if opts.AgeWeight.IsNegative() {
+		err = fmt.Errorf(`age weight must not be negative: %s`, opts.AgeWeight)
+		return
+	}
+	if opts.Fractional && (opts.WholeLots || opts.MinimizeLots || opts.Target == TargetExact || opts.PerLotFee.IsPositive() || opts.Alternatives > 0 || opts.MaxLots > 0) {
+		err = fmt.Errorf(`fractional donations are incompatible with whole lots, minimizing lots, exact targets, per-lot fees, alternatives, and maximum numbers of lots`)
+		return
+	}
+	if err = checkUnusedAssets(input, opts); err != nil {
+		return
+	}
+	if opts.MergeDuplicates {
+		input.Lots = MergeDuplicateLots(input.Lots)
+	} else if opts.RejectDuplicates {
+		if err = CheckDuplicateLots(input.Lots); err != nil {
+			return
+		}
+	}
+	if prior, err = subtractPriorDonations(input); err != nil {
+		return
+	}
+	if normalizedLots, err = NewNormalizedLots(input, opts); err != nil {
+		return
+	}
+	opts.logf(VerbosityInfo, "sharePriceExponent %d, shareExponent %d, normalized donation (knapsack capacity) %d", normalizedLots.sharePriceExponent, normalizedLots.shareExponent, normalizedLots.donation)
+	normalizedLots.FilterLotsInPlace()
+	opts.logf(VerbosityInfo, "%d lots, %d eligible after filtering, %d eligible share units", len(input.Lots), len(normalizedLots.lots), normalizedLots.GetShareUnits())
+	// The basis method sorts last so that the tie-breaking rule
+	// only orders the lots that the method considers equal.
+	if err = normalizedLots.SortLotsByTieBreak(opts.TieBreak); err != nil {
+		return
+	}
+	if err = normalizedLots.SortLotsByBasisMethod(opts.BasisMethod); err != nil {
+		return
+	}
+	return
+}
+
+// newOutputLots converts lots to OutputLots.
+func (nl *NormalizedLots) newOutputLots(lots []Lot) []OutputLot {
+	outputLots := make([]OutputLot, len(lots))
+	for m, lot := range lots {
+		outputLots[m] = OutputLot{LotJSON: *lot.json, LongTerm: lot.longTerm, index: lot.index}
+		outputLots[m].Shares = nl.LotShares(&lot)
+		outputLots[m].OriginalShares = lot.json.Shares
+		outputLots[m].Partial = outputLots[m].Shares.LessThan(lot.json.Shares)
+	}
+	return outputLots
+}
+
+// remainingLots returns nl's lots in input order
+// without the shares of donated (which are some of nl's lots),
+// omitting the lots that donated empties.
+func (nl *NormalizedLots) remainingLots(donated []Lot) (remaining []LotJSON) {
+	donatedShares := make(map[int]decimal.Decimal, len(donated))
+	for m := range donated {
+		donatedShares[donated[m].index] = donatedShares[donated[m].index].Add(nl.LotShares(&donated[m]))
+	}
+	lots := append([]Lot(nil), nl.lots...)
+	sort.SliceStable(lots, func(a, b int) bool { return lots[a].index < lots[b].index })
+	for _, lot := range lots {
+		remaining = appendRemainingLot(remaining, *lot.json, donatedShares[lot.index])
+	}
+	return
+}
+
+// appendRemainingLot appends lot to lots without donated of its shares
+// (and of its maxDonatableShares) unless donated empties it.
+func appendRemainingLot(lots []LotJSON, lot LotJSON, donated decimal.Decimal) []LotJSON {
+	lot.Shares = lot.Shares.Sub(donated)
+	if !lot.Shares.IsPositive() {
+		return lots
+	}
+	if lot.MaxDonatableShares != nil {
+		maxShares := decimal.Max(lot.MaxDonatableShares.Sub(donated), decimal.Zero)
+		lot.MaxDonatableShares = &maxShares
+	}
+	return append(lots, lot)
+}
+
+// splitGainsByTerm sets output's LongTermGains and ShortTermGains
+// to the capital gains of its long-term and short-term lots,
+// which add up to its TotalCapitalGains.
+func splitGainsByTerm(input *Input, output *Output) {
+	var longTerm, shortTerm decimal.Decimal
+	for _, lot := range output.Lots {
+		cg := input.UnitCapitalGains(&lot.LotJSON).Mul(lot.Shares)
+		if lot.LongTerm {
+			longTerm = longTerm.Add(cg)
+		} else {
+			shortTerm = shortTerm.Add(cg)
+		}
+	}
+	output.LongTermGains, output.ShortTermGains = &longTerm, &shortTerm
+}
+
+// summarizeLots sets the CapitalGains of lots
+// and returns their totals, overall and for each asset.
+func summarizeLots(input *Input, lots []OutputLot) (totalValue decimal.Decimal, totalCapitalGains decimal.Decimal, assetSummary map[string]AssetSummary) {
+	assetSummary = make(map[string]AssetSummary)
+	for m := range lots {
+		asset := &lots[m]
+		value := input.SharePrice(&asset.LotJSON).Mul(asset.Shares)
+		cg := input.UnitCapitalGains(&asset.LotJSON).Mul(asset.Shares)
+		asset.CapitalGains = cg
+		totalValue = totalValue.Add(value)
+		totalCapitalGains = totalCapitalGains.Add(cg)
+		summary := assetSummary[asset.AssetName]
+		summary.Shares = summary.Shares.Add(asset.Shares)
+		summary.TotalValue = summary.TotalValue.Add(value)
+		summary.TotalCapitalGains = summary.TotalCapitalGains.Add(cg)
+		assetSummary[asset.AssetName] = summary
+	}
+	return
+}
@@ -0,0 +1,378 @@
+package donation
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testAsOf is the Options.AsOf of the tests,
+// after all of GenerateInput's lots are long-term.
+var testAsOf = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testOptions returns the Options of a test donation of donation.
+func testOptions(donation string) Options {
+	return Options{Donation: donation, AsOf: testAsOf}
+}
+
+// testLot returns a long-term lot of assetName with shares and shareCost.
+func testLot(assetName, shares, shareCost string) LotJSON {
+	return LotJSON{AssetName: assetName, Date: "2020-01-02", Shares: decimal.RequireFromString(shares), ShareCost: decimal.RequireFromString(shareCost)}
+}
+
+// testInput returns an input of lots with the share prices of prices,
+// which alternates asset names and prices.
+func testInput(lots []LotJSON, prices ...string) Input {
+	input := Input{AssetSharePrices: make(map[string]decimal.Decimal), Lots: lots}
+	for m := 0; m+1 < len(prices); m += 2 {
+		input.AssetSharePrices[prices[m]] = decimal.RequireFromString(prices[m+1])
+	}
+	return input
+}
+
+func TestIsLongTerm(t *testing.T) {
+	tests := []struct {
+		acquired, asOf string
+		longTermDays   int
+		want           bool
+	}{
+		// More than one year, which is 366 days across a leap day.
+		{"2020-01-01", "2020-12-31", 0, false},
+		{"2020-01-01", "2021-01-01", 0, false},
+		{"2020-01-01", "2021-01-02", 0, true},
+		{"2021-01-01", "2022-01-01", 0, false},
+		{"2021-01-01", "2022-01-02", 0, true},
+		{"2020-02-29", "2021-02-28", 0, false},
+		{"2020-02-29", "2021-03-01", 0, false},
+		{"2020-02-29", "2021-03-02", 0, true},
+		{"2021-01-01", "2020-01-01", 0, false},
+
+		// Times of day do not matter.
+		{"2024-10-14", "2025-10-14T15:04:05Z", 0, false},
+		{"2024-10-14T23:59:59Z", "2025-10-15T00:00:01Z", 0, true},
+		{"2024-10-14", "2025-10-15T15:04:05Z", 0, true},
+
+		// At least longTermDays calendar days.
+		{"2021-01-01", "2022-01-01", 366, false},
+		{"2021-01-01", "2022-01-02", 366, true},
+		{"2021-01-01", "2021-01-31", 30, true},
+		{"2021-01-01", "2021-01-30", 30, false},
+		{"2021-01-01", "2021-01-01", 1, false},
+	}
+	for _, test := range tests {
+		acquired, err := ParseDate(test.acquired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asOf, err := ParseDate(test.asOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := IsLongTerm(acquired, asOf, test.longTermDays); got != test.want {
+			t.Errorf("IsLongTerm(%s, %s, %d) = %v, want %v", test.acquired, test.asOf, test.longTermDays, got, test.want)
+		}
+	}
+}
+
+// exampleInput is the example input of the README.
+const exampleInput = `{
+	"assetSharePrices": {"VTI": 100.22, "BND": 12.35},
+	"lots": [
+		{"assetName": "VTI", "date": "2019-01-02", "shares": 13, "shareCost": 50.55},
+		{"assetName": "VTI", "date": "2019-02-02", "shares": 11, "shareCost": 55.55},
+		{"assetName": "VTI", "date": "2019-03-02", "shares": 9, "shareCost": 120.22},
+		{"assetName": "BND", "date": "2019-02-03", "shares": 50, "shareCost": 10.00}
+	]
+}`
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name                          string
+		opts                          func(opts *Options)
+		donation                      string
+		wantTotalValue, wantTotalGain string
+		wantShares                    map[string]string
+	}{
+		{"over budget", func(opts *Options) {}, "1000", "988.43", "463.48", map[string]string{"2019-01-02": "9", "2019-02-03": "7"}},
+		{"small budget", func(opts *Options) {}, "250", "249.84", "108.74", map[string]string{"2019-01-02": "2", "2019-02-03": "4"}},
+		{"under budget", func(opts *Options) {}, "100000", "3022.78", "1254.58", map[string]string{"2019-01-02": "13", "2019-02-02": "11", "2019-02-03": "50"}},
+		{"losses", func(opts *Options) { opts.MaximizeLosses = true }, "1000", "901.98", "-180", map[string]string{"2019-03-02": "9"}},
+		{"whole lots", func(opts *Options) { opts.WholeLots = true }, "1500", "1302.86", "645.71", map[string]string{"2019-01-02": "13"}},
+	}
+	for _, test := range tests {
+		var input Input
+		if err := json.Unmarshal([]byte(exampleInput), &input); err != nil {
+			t.Fatal(err)
+		}
+		opts := testOptions(test.donation)
+		test.opts(&opts)
+		output, err := Optimize(input, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if output.TotalValue.String() != test.wantTotalValue || output.TotalCapitalGains.String() != test.wantTotalGain {
+			t.Errorf("%s: total value %s and capital gains %s, want %s and %s", test.name, output.TotalValue, output.TotalCapitalGains, test.wantTotalValue, test.wantTotalGain)
+		}
+		shares := make(map[string]string)
+		for _, lot := range output.Lots {
+			shares[lot.Date] = lot.Shares.String()
+		}
+		if !reflect.DeepEqual(shares, test.wantShares) {
+			t.Errorf("%s: donated shares %v, want %v", test.name, shares, test.wantShares)
+		}
+	}
+}
+
+func TestDuplicateLots(t *testing.T) {
+	const duplicates = `{
+		"assetSharePrices": {"A": 10, "B": 10},
+		"lots": [
+			{"assetName": "A", "date": "2019-01-02", "shares": 3, "shareCost": 5, "maxDonatableShares": 2},
+			{"assetName": "B", "date": "2019-01-02", "shares": 4, "shareCost": 5},
+			{"assetName": "A", "date": "2019-01-02", "shares": 6, "shareCost": 5.00, "maxDonatableShares": 5},
+			{"assetName": "A", "date": "2019-01-02T00:00:00Z", "shares": 1, "shareCost": 5},
+			{"assetName": "B", "date": "2019-01-02", "shares": 1, "shareCost": 5}
+		]
+	}`
+	tests := []struct {
+		name       string
+		opts       func(opts *Options)
+		wantErr    string
+		wantShares []string
+	}{
+		{"kept", func(opts *Options) {}, "", []string{"2", "4", "5", "1", "1"}},
+		{"rejected", func(opts *Options) { opts.RejectDuplicates = true }, "lots[2] duplicates lots[0]: lot of A acquired on 2019-01-02 with share cost 5", nil},
+		{"merged", func(opts *Options) { opts.MergeDuplicates = true }, "", []string{"7", "5", "1"}},
+		{"merged instead of rejected", func(opts *Options) { opts.MergeDuplicates, opts.RejectDuplicates = true, true }, "", []string{"7", "5", "1"}},
+	}
+	for _, test := range tests {
+		var input Input
+		if err := json.Unmarshal([]byte(duplicates), &input); err != nil {
+			t.Fatal(err)
+		}
+		opts := testOptions("1000")
+		test.opts(&opts)
+		output, err := Optimize(input, opts)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var shares []string
+		for _, lot := range output.Lots {
+			shares = append(shares, lot.Shares.String())
+		}
+		if !reflect.DeepEqual(shares, test.wantShares) {
+			t.Errorf("%s: donated shares %v, want %v", test.name, shares, test.wantShares)
+		}
+	}
+}
+
+// objectKeys returns the keys of the JSON object data in their order.
+func objectKeys(t *testing.T, data []byte) (keys []string) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		t.Fatal(err)
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key.(string))
+		var value json.RawMessage
+		if err = decoder.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func TestOutputLotsAsInput(t *testing.T) {
+	var input Input
+	if err := json.Unmarshal([]byte(`{"assetSharePrices": {"A": 10, "B": 4}, "lots": [
+		{"assetName": "A", "date": "2020-01-02", "shares": 7, "shareCost": 1, "acct": "X"},
+		{"assetName": "B", "date": "2020-01-02", "shares": 20, "shareCost": 3, "lotId": 2}]}`), &input); err != nil {
+		t.Fatal(err)
+	}
+	for round := 1; round <= 2; round++ {
+		output, err := Optimize(input, testOptions("75"))
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if len(output.Lots) != 2 {
+			t.Fatalf("round %d: donated %d lots, want 2", round, len(output.Lots))
+		}
+		// Each round donates the previous round's output lots as input lots.
+		input.Lots = nil
+		for _, lot := range output.Lots {
+			data, err := json.Marshal(lot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := make(map[string]bool)
+			for _, key := range objectKeys(t, data) {
+				if seen[key] {
+					t.Errorf("round %d: output lot %s has %q more than once", round, data, key)
+				}
+				seen[key] = true
+			}
+			if !seen["acct"] && !seen["lotId"] {
+				t.Errorf("round %d: output lot %s lacks its extra field", round, data)
+			}
+			var next LotJSON
+			if err = json.Unmarshal(data, &next); err != nil {
+				t.Fatal(err)
+			}
+			input.Lots = append(input.Lots, next)
+		}
+	}
+}
+
+func TestOutputMapOrder(t *testing.T) {
+	input := testInput([]LotJSON{testLot("b", "1", "1"), testLot("Z", "1", "1"), testLot("a", "1", "1"), testLot("M", "1", "1")}, "b", "2", "Z", "2", "a", "2", "M", "2", "B", "2")
+	output, err := Optimize(input, testOptions("100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		field string
+		want  []string
+	}{
+		{"assetSharePrices", []string{"B", "M", "Z", "a", "b"}},
+		{"assetSummary", []string{"M", "Z", "a", "b"}},
+	}
+	for _, quoting := range []Quoting{{}, {Prices: true, Totals: true}} {
+		data, err := quoting.Marshal(&output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			if keys := objectKeys(t, fields[test.field]); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("%+v: %s has keys %v, want %v", quoting, test.field, keys, test.want)
+			}
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(opts *Options)
+	}{
+		{"default", func(opts *Options) {}},
+		{"alternatives", func(opts *Options) { opts.Alternatives = 3 }},
+		{"parallel", func(opts *Options) { opts.Parallel = 4 }},
+		{"rolling", func(opts *Options) { opts.Solver = SolverRolling }},
+		{"minimize lots", func(opts *Options) { opts.MinimizeLots = true }},
+		{"whole lots", func(opts *Options) { opts.WholeLots = true }},
+		{"input tie-break", func(opts *Options) { opts.TieBreak = TieBreakInput }},
+		{"losses", func(opts *Options) { opts.MaximizeLosses = true }},
+		{"integer cents error", func(opts *Options) { opts.IntegerCents = true }},
+	}
+	for _, test := range tests {
+		var first string
+		for run := 0; run < 10; run++ {
+			// Ties abound in integer share prices and costs.
+			input := GenerateInput(GenerateOptions{Seed: 5, Assets: 4, LotsPerAsset: 4, MaxShares: 8, PriceDecimals: 1})
+			opts := testOptions("3000")
+			test.opts(&opts)
+			output, err := Optimize(input, opts)
+			var data []byte
+			if err == nil {
+				data, err = json.Marshal(&output)
+			}
+			result := string(data)
+			if err != nil {
+				result = err.Error()
+			}
+			if run == 0 {
+				first = result
+			} else if result != first {
+				t.Errorf("%s: run %d differs from the first run:\n%s\nwant:\n%s", test.name, run, result, first)
+				break
+			}
+		}
+	}
+}
+
+func TestPartialLots(t *testing.T) {
+	type lot struct {
+		shares, originalShares string
+		partial                bool
+	}
+	tests := []struct {
+		donation string
+		want     []lot
+	}{
+		{"1000", []lot{{"9", "13", true}, {"7", "50", true}}},
+		{"1302.86", []lot{{"13", "13", false}}},
+		{"100000", []lot{{"13", "13", false}, {"11", "11", false}, {"50", "50", false}}},
+	}
+	for _, test := range tests {
+		var input Input
+		if err := json.Unmarshal([]byte(exampleInput), &input); err != nil {
+			t.Fatal(err)
+		}
+		output, err := Optimize(input, testOptions(test.donation))
+		if err != nil {
+			t.Fatalf("donation %s: %v", test.donation, err)
+		}
+		var got []lot
+		for _, outputLot := range output.Lots {
+			got = append(got, lot{outputLot.Shares.String(), outputLot.OriginalShares.String(), outputLot.Partial})
+			data, err := json.Marshal(&outputLot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte(`"partial"`)) != outputLot.Partial {
+				t.Errorf("donation %s: lot JSON %s", test.donation, data)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("donation %s: donated lots %v, want %v", test.donation, got, test.want)
+		}
+	}
+}
+
+func TestEmptyInput(t *testing.T) {
+	for _, data := range []string{`{}`, `{"lots": []}`, `{"assetSharePrices": {}, "lots": null}`, `{"assetSharePrices": {"A": 2}, "lots": []}`} {
+		var input Input
+		if err := json.Unmarshal([]byte(data), &input); err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range []Options{testOptions("1000"), {Donation: "5%", AsOf: testAsOf}, {Donation: "1000", AsOf: testAsOf, Summary: true, Alternatives: 2}} {
+			output, err := Optimize(input, opts)
+			if err != nil {
+				t.Errorf("%s with donation %s: %v", data, opts.Donation, err)
+				continue
+			}
+			if len(output.Lots) != 0 || !output.TotalValue.IsZero() || !output.TotalCapitalGains.IsZero() || len(output.ExcludedLots) != 0 {
+				t.Errorf("%s with donation %s: donated %d lots worth %s with gains %s", data, opts.Donation, len(output.Lots), output.TotalValue, output.TotalCapitalGains)
+			}
+		}
+	}
+}
+
+// benchmarkLots returns the normalized lots of a donation of donation
+// from GenerateInput(generate) and their knapsack items from SplitLots.
+func benchmarkLots(tb testing.TB, generate GenerateOptions, donation string) (NormalizedLots, []Lot) {
+	input, opts := GenerateInput(generate), testOptions(donation)
+	nl, _, err := prepare(&input, &opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	_, pricedLots := PartitionFreeLots(nl.lots)
+	return nl, SplitLots(pricedLots)
+}
@@ -0,0 +1,32 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+)
+
+// explain writes a line for each lot in input to w explaining
+// whether nl excluded it and how many of its shares donationLots contain.
+func explain(w io.Writer, input *Input, nl *NormalizedLots, donationLots []Lot) {
+	reasons := make(map[int]ExclusionReason, len(nl.excluded))
+	for _, lot := range nl.excluded {
+		reasons[lot.index] = lot.Reason
+	}
+	donatedShares := make(map[int]decimal.Decimal, len(donationLots))
+	for m := range donationLots {
+		lot := &donationLots[m]
+		donatedShares[lot.index] = donatedShares[lot.index].Add(nl.LotShares(lot))
+	}
+	for m := range input.Lots {
+		lot := &input.Lots[m]
+		fmt.Fprintf(w, "lot %d (%s acquired on %s): unit capital gains %s; ", m, lot.AssetName, lot.Date, input.UnitCapitalGains(lot))
+		if reason, ok := reasons[m]; ok {
+			fmt.Fprintf(w, "excluded (%s)\n", reason)
+		} else if shares, ok := donatedShares[m]; ok {
+			fmt.Fprintf(w, "donating %s of %s shares\n", shares, lot.Shares)
+		} else {
+			fmt.Fprintf(w, "eligible but not donated\n")
+		}
+	}
+}
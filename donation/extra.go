@@ -0,0 +1,99 @@
+package donation
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// lotFields has the fields of LotJSON without its JSON methods.
+type lotFields LotJSON
+
+// lotFieldNames are the JSON names of the fields of LotJSON
+// and the output lots, which cannot be extra fields.
+var lotFieldNames = map[string]bool{
+	"assetName":          true,
+	"date":               true,
+	"shares":             true,
+	"shareCost":          true,
+	"maxDonatableShares": true,
+	"sharePrice":         true,
+	"cash":               true,
+	"pinned":             true,
+	"longTerm":           true,
+	"originalShares":     true,
+	"capitalGains":       true,
+	"partial":            true,
+	"reason":             true,
+}
+
+// UnmarshalJSON unmarshals a lot and keeps its unknown fields in Extra.
+func (lot *LotJSON) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*lotFields)(lot)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	lot.Extra = nil
+	for name, value := range fields {
+		if !lotFieldNames[name] {
+			if lot.Extra == nil {
+				lot.Extra = make(map[string]json.RawMessage)
+			}
+			lot.Extra[name] = value
+		}
+	}
+	return nil
+}
+
+// MarshalJSON marshals a lot followed by its extra fields.
+func (lot LotJSON) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(lotFields(lot), lot.Extra)
+}
+
+// MarshalJSON marshals a donated lot followed by its extra fields.
+func (lot OutputLot) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(struct {
+		lotFields
+		LongTerm       bool            `json:"longTerm"`
+		OriginalShares decimal.Decimal `json:"originalShares"`
+		CapitalGains   decimal.Decimal `json:"capitalGains"`
+		Partial        bool            `json:"partial,omitempty"`
+	}{lotFields(lot.LotJSON), lot.LongTerm, lot.OriginalShares, lot.CapitalGains, lot.Partial}, lot.Extra)
+}
+
+// MarshalJSON marshals an excluded lot followed by its extra fields.
+func (lot OutputExcludedLot) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(struct {
+		lotFields
+		Reason ExclusionReason `json:"reason"`
+	}{lotFields(lot.LotJSON), lot.Reason}, lot.Extra)
+}
+
+// marshalWithExtra marshals object, which must marshal to a JSON object,
+// and appends the fields in extra sorted by name.
+func marshalWithExtra(object interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(object)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buffer bytes.Buffer
+	buffer.Write(data[:len(data)-1])
+	for _, name := range names {
+		key, _ := json.Marshal(name)
+		buffer.WriteByte(',')
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(extra[name])
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"math/rand"
+	"time"
+)
+
+// GenerateOptions controls the synthetic Input that GenerateInput returns.
+type GenerateOptions struct {
+	// Seed seeds the random number generator,
+	// so the same GenerateOptions always produce the same Input.
+	Seed int64
+
+	// Assets is the number of assets (named ASSET0, ASSET1, ...).
+	Assets int
+
+	// LotsPerAsset is the number of lots of each asset.
+	LotsPerAsset int
+
+	// MaxShares is the maximum number of shares in a lot.
+	MaxShares int
+
+	// PriceDecimals is the number of decimal places
+	// in share prices and costs.
+	PriceDecimals int32
+
+	// ShareDecimals is the number of decimal places in lots' shares.
+	ShareDecimals int32
+}
+
+// generateEpoch is the earliest date of a generated lot.
+var generateEpoch = time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// GenerateInput returns a synthetic Input for measuring
+// how long Optimize takes on problems of various sizes.
+// Share prices are between 1 and 1000, shares are between 0 and MaxShares,
+// lots' costs are between 20% and 150% of their assets' prices
+// (so some lots have capital losses),
+// and lots' dates are between 2010 and 2019 (so they are long-term).
+func GenerateInput(opts GenerateOptions) (input Input) {
+	random := rand.New(rand.NewSource(opts.Seed))
+	// fraction returns a random fraction with the specified number of
+	// decimal places.
+	fraction := func(decimals int32) decimal.Decimal {
+		return decimal.New(random.Int63n(decimal.New(1, decimals).IntPart()), -decimals)
+	}
+	input.AssetSharePrices = make(map[string]decimal.Decimal, opts.Assets)
+	for a := 0; a < opts.Assets; a++ {
+		name := fmt.Sprintf("ASSET%d", a)
+		price := decimal.NewFromInt(1 + random.Int63n(999)).Add(fraction(opts.PriceDecimals))
+		input.AssetSharePrices[name] = price
+		for m := 0; m < opts.LotsPerAsset; m++ {
+			costPercent := decimal.NewFromInt(20 + random.Int63n(131))
+			shares := decimal.NewFromInt(1 + random.Int63n(int64(opts.MaxShares))).Sub(fraction(opts.ShareDecimals))
+			input.Lots = append(input.Lots, LotJSON{
+				AssetName: name,
+				Date:      generateEpoch.AddDate(0, 0, random.Intn(3650)).Format(dateLayouts[0]),
+				Shares:    shares,
+				ShareCost: price.Mul(costPercent).Shift(-2).Truncate(opts.PriceDecimals)})
+		}
+	}
+	return
+}
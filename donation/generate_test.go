@@ -0,0 +1,87 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGenerateInput(t *testing.T) {
+	tests := []GenerateOptions{
+		{Seed: 1, Assets: 1, LotsPerAsset: 1, MaxShares: 1},
+		{Seed: 2, Assets: 3, LotsPerAsset: 4, MaxShares: 50, PriceDecimals: 2},
+		{Seed: 3, Assets: 5, LotsPerAsset: 2, MaxShares: 10, PriceDecimals: 4, ShareDecimals: 3},
+	}
+	for _, test := range tests {
+		input := GenerateInput(test)
+		if again := GenerateInput(test); !reflect.DeepEqual(input, again) {
+			t.Errorf("%+v: inputs differ", test)
+		}
+		other := test
+		other.Seed++
+		if reflect.DeepEqual(input, GenerateInput(other)) {
+			t.Errorf("%+v: the next seed generates the same input", test)
+		}
+		if len(input.AssetSharePrices) != test.Assets || len(input.Lots) != test.Assets*test.LotsPerAsset {
+			t.Errorf("%+v: %d assets and %d lots", test, len(input.AssetSharePrices), len(input.Lots))
+		}
+		for _, lot := range input.Lots {
+			price := input.AssetSharePrices[lot.AssetName]
+			if !lot.Shares.IsPositive() || lot.Shares.GreaterThan(decimal.NewFromInt(int64(test.MaxShares))) {
+				t.Errorf("%+v: %s shares of %s", test, lot.Shares, lot.AssetName)
+			}
+			if -lot.Shares.Exponent() > test.ShareDecimals || -price.Exponent() > test.PriceDecimals || -lot.ShareCost.Exponent() > test.PriceDecimals {
+				t.Errorf("%+v: %s shares of %s at a price of %s and a cost of %s have too many decimal places", test, lot.Shares, lot.AssetName, price, lot.ShareCost)
+			}
+			if date, err := time.Parse(dateLayouts[0], lot.Date); err != nil || !IsLongTerm(date, testAsOf, 0) {
+				t.Errorf("%+v: lot of %s from %s is short-term", test, lot.AssetName, lot.Date)
+			}
+		}
+	}
+}
+
+// benchmarkSizes are the sizes of the generated inputs
+// of BenchmarkNewNormalizedLots and BenchmarkOptimize.
+var benchmarkSizes = []GenerateOptions{
+	{Seed: 1, Assets: 2, LotsPerAsset: 5, MaxShares: 20, PriceDecimals: 2},
+	{Seed: 1, Assets: 5, LotsPerAsset: 10, MaxShares: 100, PriceDecimals: 2},
+	{Seed: 1, Assets: 10, LotsPerAsset: 20, MaxShares: 100, PriceDecimals: 1, ShareDecimals: 1},
+}
+
+// benchmarkName returns the name of the sub-benchmark of generate.
+func benchmarkName(generate GenerateOptions) string {
+	return fmt.Sprintf("assets=%d/lots=%d/shares=%d/decimals=%d,%d", generate.Assets, generate.LotsPerAsset, generate.MaxShares, generate.PriceDecimals, generate.ShareDecimals)
+}
+
+func BenchmarkNewNormalizedLots(b *testing.B) {
+	for _, generate := range benchmarkSizes {
+		input := GenerateInput(generate)
+		b.Run(benchmarkName(generate), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				opts := testOptions("1000")
+				nl, err := NewNormalizedLots(&input, &opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				nl.FilterLotsInPlace()
+			}
+		})
+	}
+}
+
+func BenchmarkOptimize(b *testing.B) {
+	for _, generate := range benchmarkSizes {
+		input := GenerateInput(generate)
+		b.Run(benchmarkName(generate), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if _, err := Optimize(input, testOptions("1000")); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package donation
+
+import (
+	"context"
+	"errors"
+	"github.com/shopspring/decimal"
+	"math/bits"
+	"sort"
+)
+
+// GreedySolution returns the shares of nl's lots that a greedy algorithm
+// donates in the order of nl's lots: it takes the lots
+// with the most value (see score) per unit of price first,
+// each with as many shares as the rest of the donation allows
+// (or, with Options.WholeLots, all of them if they fit),
+// until it has taken nl.maxLots lots (if it is positive).
+// It is fast but not optimal.
+func (nl *NormalizedLots) GreedySolution() (donationLots []Lot) {
+	order := nl.greedyOrder(nl.score)
+	shares := make([]uint64, len(nl.lots))
+	remaining := nl.donation
+	taken := 0
+	for _, m := range order {
+		if nl.maxLots > 0 && taken == nl.maxLots {
+			break
+		}
+		lot := &nl.lots[m]
+		if lot.price == 0 {
+			shares[m] = lot.shares
+			taken++
+			continue
+		}
+		shares[m] = remaining / lot.price
+		if shares[m] >= lot.shares {
+			shares[m] = lot.shares
+		} else if nl.wholeLots {
+			shares[m] = 0
+		}
+		remaining -= shares[m] * lot.price
+		if shares[m] > 0 {
+			taken++
+		}
+	}
+	for m, lot := range nl.lots {
+		if shares[m] != 0 {
+			lot.shares = shares[m]
+			donationLots = append(donationLots, lot)
+		}
+	}
+	return
+}
+
+// fractionalPrecision is the number of decimal places of a share unit
+// to which FractionalSolution rounds the fractional share units
+// that it donates (down, so that they never exceed the donation).
+const fractionalPrecision = 8
+
+// FractionalSolution returns the optimal donation of nl's lots
+// if any fraction of a share unit can be donated
+// (the fractional knapsack problem):
+// like GreedySolution, it takes the most valuable lots per unit of price
+// first (weighing their values by age; see weighByAge)
+// and as many whole share units of each as fit,
+// but it also donates the fraction of the next share unit
+// (see Lot.fraction) that fills the rest of the donation.
+// Its total value is at least that of any donation of whole share units.
+//
+// This function runs in O(l*log(l)) time, where l is the number of lots.
+func (nl *NormalizedLots) FractionalSolution() (donationLots []Lot) {
+	order := nl.greedyOrder(func(lot *Lot) int64 { return nl.weighByAge(lot, nl.score(lot)) })
+	lots := append([]Lot(nil), nl.lots...)
+	remaining := nl.donation
+	for _, m := range order {
+		lot := &lots[m]
+		if lot.price == 0 || lot.shares*lot.price <= remaining {
+			remaining -= lot.shares * lot.price
+			continue
+		}
+		if remaining > 0 {
+			lot.fraction, _ = decimal.New(int64(remaining%lot.price), 0).QuoRem(decimal.New(int64(lot.price), 0), fractionalPrecision)
+		}
+		lot.shares = remaining / lot.price
+		remaining = 0
+	}
+	for _, lot := range lots {
+		if lot.shares != 0 || lot.fraction.IsPositive() {
+			donationLots = append(donationLots, lot)
+		}
+	}
+	return
+}
+
+// greedyOrder returns the indexes of nl's lots
+// in decreasing order of value (from value) per unit of price.
+func (nl *NormalizedLots) greedyOrder(value func(lot *Lot) int64) (order []int) {
+	order = make([]int, len(nl.lots))
+	for m := range order {
+		order[m] = m
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		x, y := &nl.lots[order[a]], &nl.lots[order[b]]
+		return valuePerPriceGreater(uint64(value(x)), x.price, uint64(value(y)), y.price)
+	})
+	return
+}
+
+// valuePerPriceGreater reports whether value x per price p
+// exceeds value y per price q (so a price of zero is the greatest).
+func valuePerPriceGreater(x, p, y, q uint64) bool {
+	xqHi, xqLo := bits.Mul64(x, q)
+	ypHi, ypLo := bits.Mul64(y, p)
+	return xqHi > ypHi || (xqHi == ypHi && xqLo > ypLo)
+}
+
+// chooseLotsWithin is chooseLots, except that if opts.Timeout is positive
+// and chooseLots does not finish before its deadline
+// (see Options.timeoutContext), chooseLotsWithin
+// returns the lots that chooseLots chooses with GreedySolution instead
+// and sets approximate.
+// The solvers stop soon after the deadline (see NormalizedLots.ctx),
+// so nothing else runs while the greedy solution is chosen.
+func (nl *NormalizedLots) chooseLotsWithin(opts *Options) (donationLots []Lot, approximate bool, err error) {
+	donationLots, err = nl.chooseLots(opts)
+	if !nl.timedOut(opts, err) {
+		return
+	}
+	opts.logf(VerbosityInfo, "solver did not finish within %v, so donating a greedy solution", opts.Timeout)
+	ctx := nl.ctx
+	nl.greedy, nl.ctx = true, nil
+	defer func() {
+		nl.greedy, nl.ctx = false, ctx
+	}()
+	donationLots, err = nl.chooseLots(opts)
+	return donationLots, true, err
+}
+
+// timedOut reports whether err is the error that chooseLots returns
+// when opts.Timeout (instead of opts.Context) stopped its solver.
+func (nl *NormalizedLots) timedOut(opts *Options, err error) bool {
+	return err != nil && opts.timeoutContext != nil && errors.Is(err, context.DeadlineExceeded) &&
+		(opts.Context == nil || opts.Context.Err() == nil)
+}
@@ -0,0 +1,68 @@
+package donation
+
+import (
+	"context"
+	"errors"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	input := GenerateInput(GenerateOptions{Seed: 1, Assets: 4, LotsPerAsset: 5, MaxShares: 20})
+	tests := []struct {
+		name            string
+		opts            func(opts *Options)
+		wantApproximate bool
+	}{
+		{"no timeout", func(opts *Options) {}, false},
+		{"long timeout", func(opts *Options) { opts.Timeout = time.Hour }, false},
+		{"expired timeout", func(opts *Options) { opts.Timeout = time.Nanosecond }, true},
+		{"expired timeout with max lots", func(opts *Options) { opts.Timeout, opts.MaxLots = time.Nanosecond, 2 }, true},
+		{"expired timeout with minimize lots", func(opts *Options) { opts.Timeout, opts.MinimizeLots = time.Nanosecond, true }, true},
+		{"expired timeout with parallel", func(opts *Options) { opts.Timeout, opts.Parallel = time.Nanosecond, 4 }, true},
+		{"live context", func(opts *Options) { opts.Context = context.Background() }, false},
+	}
+	exact, err := Optimize(input, testOptions("5000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		opts := testOptions("5000")
+		opts.Alternatives = 2
+		opts.MarginalStep = decimal.NewFromInt(100)
+		test.opts(&opts)
+		output, err := Optimize(input, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if output.Approximate != test.wantApproximate || output.Marginal.Approximate != test.wantApproximate {
+			t.Errorf("%s: approximate %v (marginal %v), want %v", test.name, output.Approximate, output.Marginal.Approximate, test.wantApproximate)
+		}
+		if test.wantApproximate && len(output.Alternatives) > 0 {
+			t.Errorf("%s: %d alternatives of an approximate donation", test.name, len(output.Alternatives))
+		}
+		if output.TotalValue.GreaterThan(output.DonationAmount) {
+			t.Errorf("%s: total value %s exceeds the donation of %s", test.name, output.TotalValue, output.DonationAmount)
+		}
+		if opts.MaxLots == 0 && !opts.MinimizeLots && output.TotalCapitalGains.GreaterThan(exact.TotalCapitalGains) {
+			t.Errorf("%s: total capital gains %s exceed the optimum of %s", test.name, output.TotalCapitalGains, exact.TotalCapitalGains)
+		}
+		if !test.wantApproximate && !output.TotalCapitalGains.Equal(exact.TotalCapitalGains) {
+			t.Errorf("%s: total capital gains %s, want %s", test.name, output.TotalCapitalGains, exact.TotalCapitalGains)
+		}
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	input := GenerateInput(GenerateOptions{Seed: 1, Assets: 4, LotsPerAsset: 5, MaxShares: 20})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, timeout := range []time.Duration{0, time.Nanosecond, time.Hour} {
+		opts := testOptions("5000")
+		opts.Context, opts.Timeout = ctx, timeout
+		if _, err := Optimize(input, opts); !errors.Is(err, context.Canceled) {
+			t.Errorf("timeout %v: error %v, want %v", timeout, err, context.Canceled)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package donation
+
+// Verbosity levels for Options.Verbosity
+const (
+	// Log the normalization, the filtering, and the solver's result.
+	VerbosityInfo = 1
+
+	// Also log each knapsack problem that the solvers solve.
+	VerbosityDebug = 2
+)
+
+// logPrefixes are the prefixes of the messages at each verbosity level.
+var logPrefixes = map[int]string{
+	VerbosityInfo:  "info: ",
+	VerbosityDebug: "debug: "}
+
+// logf logs a message to opts.Logger
+// if opts.Verbosity is at least level.
+func (opts *Options) logf(level int, format string, args ...interface{}) {
+	if opts.Logger != nil && opts.Verbosity >= level {
+		opts.Logger.Printf(logPrefixes[level]+format, args...)
+	}
+}
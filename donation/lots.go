@@ -0,0 +1,989 @@
+package donation
+
+import (
+	"context"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Lot struct {
+	json *LotJSON
+
+	// index of json in Input.Lots
+	index int
+
+	// number of share units (see NormalizedLots.shareExponent)
+	shares uint64
+
+	// normalized share price (see Input.SharePrice)
+	price uint64
+
+	cost     uint64
+	acquired time.Time
+	longTerm bool
+
+	// ageScale plus Options.AgeWeight times ageScale
+	// for each year that the lot has been held (rounded down)
+	// if NormalizedLots.ageWeighted is set
+	ageFactor int64
+
+	// the fraction of a share unit that FractionalSolution donates
+	// beyond shares (zero otherwise)
+	fraction decimal.Decimal
+}
+
+// ageScale is the Lot.ageFactor of a lot acquired on Options.AsOf.
+const ageScale = 1000000
+
+type NormalizedLots struct {
+	lots []Lot
+
+	// lots removed by FilterLotsInPlace
+	excluded []ExcludedLot
+
+	// donation converted to an integer
+	// after shifting by -(sharePriceExponent + shareExponent)
+	donation uint64
+
+	// the donation amount before normalization
+	donationAmount decimal.Decimal
+
+	// the donation amount minus Options.FeePercent of it
+	// (truncated to the precision of donation),
+	// which the normalized donation represents
+	budget decimal.Decimal
+
+	// the objective and filtering modes from Options
+	maximizeLosses   bool
+	includeShortTerm bool
+
+	// Options.MinLotGain (not normalized)
+	minLotGain decimal.Decimal
+
+	// whether Options.Objective is ObjectiveEfficiency
+	efficiency bool
+
+	// whether Options.AgeWeight is positive
+	ageWeighted bool
+
+	// Options.WholeLots
+	wholeLots bool
+
+	// Options.Fractional
+	fractional bool
+
+	// the maximum number of lots that chooseLots may donate
+	// (Options.MaxLots once capLots applies it, less the lots
+	// that chooseLots has already donated; zero for no maximum)
+	maxLots int
+
+	// whether chooseLots uses GreedySolution (see chooseLotsWithin)
+	greedy bool
+
+	// Options.Progress
+	progress func(done, total uint64)
+
+	// the context whose end stops the solvers (see canceled),
+	// which ends with Options.Context or at the deadline of Options.Timeout
+	// (nil if there is neither)
+	ctx context.Context
+
+	// Options.ValueFunc
+	valueFunc func(lot *LotJSON, value int64) int64
+
+	// Options.PerLotFee in units of 10^(sharePriceExponent + shareExponent)
+	// (rounded up)
+	lotFee int64
+
+	// the assets from Options.ExcludeAssets
+	excludedAssets map[string]bool
+
+	// the assets from Options.OnlyAssets (nil for all assets)
+	onlyAssets map[string]bool
+
+	// the assets whose lots cannot be sold at a loss
+	// because of recent purchases (only when maximizing losses)
+	washSaleAssets map[string]bool
+
+	// minimum exponent from AssetSharePrices, the lots' share prices
+	// and costs, the cash lots' amounts, and the donation amount
+	// (Prices and costs are converted to integers
+	// after shifting by -sharePriceExponent
+	// to make the knapsack algorithm work.)
+	sharePriceExponent int32
+
+	// minimum exponent from the shares of the lots other than cash
+	// (at most zero),
+	// which makes each share unit 10^shareExponent shares
+	// (so fractional shares become integers)
+	//
+	// Each share unit's normalized price is its lot's price
+	// (except that each share unit of cash is one normalized unit of money),
+	// so normalized prices, costs, and gains of share units
+	// and the normalized donation are all in units of
+	// 10^(sharePriceExponent + shareExponent).
+	shareExponent int32
+}
+
+func NewNormalizedLots(input *Input, opts *Options) (nl NormalizedLots, err error) {
+	donation := opts.Donation
+	isPercentage := strings.HasSuffix(donation, "%")
+	donationDecimal, err := decimal.NewFromString(strings.TrimSuffix(donation, "%"))
+	if err != nil {
+		err = fmt.Errorf(`invalid donation amount %q: %w`, donation, err)
+		return
+	}
+	if !donationDecimal.IsPositive() {
+		err = fmt.Errorf(`donation amount must be positive: %s`, donation)
+		return
+	}
+	if isPercentage {
+		if donationDecimal.GreaterThan(decimal.NewFromInt(100)) {
+			err = fmt.Errorf(`donation percentage must not exceed 100%%: %s`, donation)
+			return
+		}
+	} else if tooLarge(donationDecimal, opts.MaxAmount) {
+		err = fmt.Errorf(`donation amount exceeds the maximum of %s: %s; check it`, opts.MaxAmount, donation)
+		return
+	}
+	nl.maximizeLosses = opts.MaximizeLosses
+	nl.includeShortTerm = opts.IncludeShortTerm
+	nl.minLotGain = opts.MinLotGain
+	nl.efficiency = opts.Objective == ObjectiveEfficiency
+	nl.ageWeighted = opts.AgeWeight.IsPositive()
+	nl.wholeLots = opts.WholeLots
+	nl.fractional = opts.Fractional
+	nl.progress = opts.Progress
+	nl.valueFunc = opts.ValueFunc
+	nl.excludedAssets = make(map[string]bool, len(opts.ExcludeAssets))
+	for _, name := range opts.ExcludeAssets {
+		nl.excludedAssets[name] = true
+	}
+	if opts.OnlyAssets != nil {
+		nl.onlyAssets = make(map[string]bool, len(opts.OnlyAssets))
+		for _, name := range opts.OnlyAssets {
+			nl.onlyAssets[name] = true
+		}
+	}
+	for _, lot := range input.Lots {
+		if !lot.Shares.IsPositive() {
+			err = fmt.Errorf(`lot of %s acquired on %s must have a positive number of shares: %s`, lot.AssetName, lot.Date, lot.Shares)
+			return
+		}
+		if lot.Cash {
+			if tooLarge(lot.Shares, opts.MaxAmount) {
+				err = fmt.Errorf(`cash lot %s acquired on %s exceeds the maximum amount of %s: %s; check it`, lot.AssetName, lot.Date, opts.MaxAmount, lot.Shares)
+				return
+			}
+			continue
+		}
+		if tooLarge(lot.Shares, opts.MaxShares) {
+			err = fmt.Errorf(`lot of %s acquired on %s has more than the maximum of %s shares: %s; check it`, lot.AssetName, lot.Date, opts.MaxShares, lot.Shares)
+			return
+		}
+		if tooLarge(lot.ShareCost, opts.MaxAmount) {
+			err = fmt.Errorf(`lot of %s acquired on %s has a shareCost greater than the maximum of %s: %s; check it`, lot.AssetName, lot.Date, opts.MaxAmount, lot.ShareCost)
+			return
+		}
+		if lot.ShareCost.IsNegative() {
+			err = fmt.Errorf(`lot of %s acquired on %s must not have a negative shareCost: %s`, lot.AssetName, lot.Date, lot.ShareCost)
+			return
+		}
+		if tooPrecise(lot.ShareCost, opts.MaxPricePrecision) {
+			err = fmt.Errorf(`lot of %s acquired on %s has a shareCost with more than %d decimal places: %s; round it`, lot.AssetName, lot.Date, opts.MaxPricePrecision, lot.ShareCost)
+			return
+		}
+		if lot.SharePrice != nil {
+			if lot.SharePrice.IsNegative() {
+				err = fmt.Errorf(`lot of %s acquired on %s must not have a negative sharePrice: %s`, lot.AssetName, lot.Date, *lot.SharePrice)
+				return
+			}
+			if tooLarge(*lot.SharePrice, opts.MaxAmount) {
+				err = fmt.Errorf(`lot of %s acquired on %s has a sharePrice greater than the maximum of %s: %s; check it`, lot.AssetName, lot.Date, opts.MaxAmount, *lot.SharePrice)
+				return
+			}
+			if tooPrecise(*lot.SharePrice, opts.MaxPricePrecision) {
+				err = fmt.Errorf(`lot of %s acquired on %s has a sharePrice with more than %d decimal places: %s; round it`, lot.AssetName, lot.Date, opts.MaxPricePrecision, *lot.SharePrice)
+				return
+			}
+		} else if _, ok := input.AssetSharePrices[lot.AssetName]; !ok {
+			err = fmt.Errorf(`lot has an assetName that does not appear in assetSharePrices: %s`, lot.AssetName)
+			return
+		}
+	}
+	for _, name := range input.assetNames() {
+		value := input.AssetSharePrices[name]
+		if value.IsNegative() {
+			err = fmt.Errorf(`share price of %s must not be negative: %s`, name, value)
+			return
+		}
+		if tooLarge(value, opts.MaxAmount) {
+			err = fmt.Errorf(`share price of %s is greater than the maximum of %s: %s; check it`, name, opts.MaxAmount, value)
+			return
+		}
+		if tooPrecise(value, opts.MaxPricePrecision) {
+			err = fmt.Errorf(`share price of %s has more than %d decimal places: %s; round it`, name, opts.MaxPricePrecision, value)
+			return
+		}
+	}
+	if opts.IntegerCents {
+		var amount *decimal.Decimal
+		if !isPercentage {
+			amount = &donationDecimal
+		}
+		if err = checkIntegerCents(input, amount); err != nil {
+			return
+		}
+		_, nl.shareExponent = NormalizationExponents(input, nil)
+	} else if isPercentage {
+		nl.sharePriceExponent, nl.shareExponent = NormalizationExponents(input, nil)
+	} else {
+		nl.sharePriceExponent, nl.shareExponent = NormalizationExponents(input, &donationDecimal)
+	}
+
+	if isPercentage {
+		// Round the donation amount down to the precision of the prices and costs
+		// so that the percentage does not make the knapsack larger.
+		// A percentage of an input without lots is zero,
+		// which is fine because there is nothing to donate anyway.
+		donationDecimal = input.GetTotalValue().Mul(donationDecimal).Shift(-2).Truncate(-nl.sharePriceExponent)
+		if !donationDecimal.IsPositive() && len(input.Lots) > 0 {
+			err = fmt.Errorf(`donation amount %s of the total value of all lots is zero`, donation)
+			return
+		}
+		// Check the resolved amount as an absolute amount is checked above.
+		if tooLarge(donationDecimal, opts.MaxAmount) {
+			err = fmt.Errorf(`donation amount %s of the total value of all lots exceeds the maximum of %s: %s; check it`, donation, opts.MaxAmount, donationDecimal)
+			return
+		}
+	}
+	nl.donationAmount = donationDecimal
+	if opts.PerLotFee.IsPositive() {
+		lotFee, ok := ShiftToInteger(opts.PerLotFee.Shift(-(nl.sharePriceExponent + nl.shareExponent)).Ceil(), 0)
+		if !ok {
+			err = fmt.Errorf(`per-lot fee is too large: %s`, opts.PerLotFee)
+			return
+		}
+		nl.lotFee = int64(lotFee)
+	}
+
+	// Subtract the fee before normalizing so that the budget
+	// is rounded down once instead of the donation being rounded first.
+	nl.budget = donationDecimal.Sub(donationDecimal.Mul(opts.FeePercent).Shift(-2)).Truncate(-(nl.sharePriceExponent + nl.shareExponent))
+	var ok bool
+	if nl.donation, ok = ShiftToInteger(nl.budget, nl.sharePriceExponent+nl.shareExponent); !ok {
+		err = fmt.Errorf(`donation amount is too large or too precise: %s`, donation)
+		return
+	}
+	if nl.maximizeLosses {
+		nl.washSaleAssets = make(map[string]bool)
+		for _, purchase := range input.RecentPurchases {
+			purchased, dateErr := ParseDate(purchase.Date)
+			if dateErr != nil {
+				err = fmt.Errorf(`recent purchase of %s has an invalid date: %q`, purchase.AssetName, purchase.Date)
+				return
+			}
+			if IsWashSale(purchased, opts.SaleDate) {
+				nl.washSaleAssets[purchase.AssetName] = true
+			}
+		}
+	}
+	nl.lots = make([]Lot, len(input.Lots))
+	for m := range input.Lots {
+		acquired, dateErr := ParseDate(input.Lots[m].Date)
+		if dateErr != nil {
+			err = fmt.Errorf(`lot of %s has an invalid date: %q`, input.Lots[m].AssetName, input.Lots[m].Date)
+			return
+		}
+		nl.lots[m] = Lot{
+			json:     &input.Lots[m],
+			index:    m,
+			acquired: acquired,
+			longTerm: IsLongTerm(acquired, opts.AsOf, opts.LongTermDays)}
+		if nl.ageWeighted {
+			days := decimal.NewFromInt(int64(HeldDays(acquired, opts.AsOf)))
+			if days.IsNegative() {
+				days = decimal.Zero
+			}
+			scale := decimal.NewFromInt(ageScale)
+			factor := scale.Add(opts.AgeWeight.Mul(days).Mul(scale).Div(decimal.NewFromInt(365))).Floor()
+			if !factor.LessThan(decimal.NewFromInt(1 << 32)) {
+				err = fmt.Errorf(`age weight is too large: %s`, opts.AgeWeight)
+				return
+			}
+			nl.lots[m].ageFactor = factor.IntPart()
+		}
+		shareExponent := nl.shareExponent
+		if input.Lots[m].Cash {
+			shareExponent = nl.sharePriceExponent + nl.shareExponent
+		}
+		if nl.lots[m].shares, ok = ShiftToInteger(input.Lots[m].Shares, shareExponent); !ok {
+			err = fmt.Errorf(`lot of %s acquired on %s has an invalid number of shares: %s`, input.Lots[m].AssetName, input.Lots[m].Date, input.Lots[m].Shares)
+			return
+		}
+		if maxShares := input.Lots[m].MaxDonatableShares; maxShares != nil {
+			maxShareUnits, ok := ShiftToInteger(*maxShares, shareExponent)
+			if !ok {
+				err = fmt.Errorf(`lot of %s acquired on %s has an invalid maxDonatableShares: %s`, input.Lots[m].AssetName, input.Lots[m].Date, *maxShares)
+				return
+			}
+			if maxShareUnits < nl.lots[m].shares {
+				nl.lots[m].shares = maxShareUnits
+			}
+		}
+		if input.Lots[m].Cash {
+			// Each share unit of cash is one normalized unit of money
+			// and costs what it is worth.
+			nl.lots[m].price, nl.lots[m].cost = 1, 1
+			continue
+		}
+		if nl.lots[m].cost, ok = nl.normalize(input.Lots[m].ShareCost); !ok {
+			err = fmt.Errorf(`lot of %s acquired on %s has a shareCost that is too large or too precise: %s`, input.Lots[m].AssetName, input.Lots[m].Date, input.Lots[m].ShareCost)
+			return
+		}
+		price := input.SharePrice(&input.Lots[m])
+		if nl.lots[m].price, ok = nl.normalize(price); !ok {
+			if input.Lots[m].SharePrice != nil {
+				err = fmt.Errorf(`lot of %s acquired on %s has a sharePrice that is too large or too precise: %s`, input.Lots[m].AssetName, input.Lots[m].Date, price)
+			} else {
+				err = fmt.Errorf(`share price of %s is too large or too precise: %s`, input.Lots[m].AssetName, price)
+			}
+			return
+		}
+	}
+	return
+}
+
+// NormalizationExponents returns the exponents by which NewNormalizedLots
+// normalizes input (see NormalizedLots.sharePriceExponent
+// and NormalizedLots.shareExponent): the minimum exponent
+// of the share prices, the costs of lots other than cash,
+// the donation amount (unless it is nil, as it is for percentages),
+// and the significant digits of the cash amounts,
+// and the minimum exponent (at most zero) of the significant digits
+// of the shares of lots other than cash.
+// Prices, costs, and the donation amount count their trailing zeros.
+func NormalizationExponents(input *Input, donation *decimal.Decimal) (sharePriceExponent, shareExponent int32) {
+	if donation != nil {
+		sharePriceExponent = donation.Exponent()
+	}
+	minimize := func(exponent *int32, d decimal.Decimal) {
+		if d.Exponent() < *exponent {
+			*exponent = d.Exponent()
+		}
+	}
+	minimizeSignificant := func(exponent *int32, d decimal.Decimal) {
+		if significant := significantExponent(d); significant < *exponent {
+			*exponent = significant
+		}
+	}
+	for _, lot := range input.Lots {
+		if lot.Cash {
+			// Cash amounts are as precise as prices
+			// so that they do not make other lots' shares fractional.
+			minimizeSignificant(&sharePriceExponent, lot.Shares)
+			if lot.MaxDonatableShares != nil {
+				minimizeSignificant(&sharePriceExponent, *lot.MaxDonatableShares)
+			}
+			continue
+		}
+		minimize(&sharePriceExponent, lot.ShareCost)
+		minimizeSignificant(&shareExponent, lot.Shares)
+		if lot.MaxDonatableShares != nil {
+			minimizeSignificant(&shareExponent, *lot.MaxDonatableShares)
+		}
+		if lot.SharePrice != nil {
+			minimize(&sharePriceExponent, *lot.SharePrice)
+		}
+	}
+	for _, price := range input.AssetSharePrices {
+		minimize(&sharePriceExponent, price)
+	}
+	return
+}
+
+// normalize shifts d by -sharePriceExponent and converts it to an integer.
+func (nl *NormalizedLots) normalize(d decimal.Decimal) (uint64, bool) {
+	return ShiftToInteger(d, nl.sharePriceExponent)
+}
+
+// ShiftToInteger shifts d by -exponent and converts it to an integer.
+// It returns false if the result is not an integer (so normalization
+// never rounds), is negative, or does not fit in an int64
+// (which UnitCapitalGains requires).
+func ShiftToInteger(d decimal.Decimal, exponent int32) (uint64, bool) {
+	shifted := d.Shift(-exponent)
+	if !shifted.IsInteger() {
+		return 0, false
+	}
+	n := shifted.BigInt()
+	if !n.IsInt64() || n.Sign() < 0 {
+		return 0, false
+	}
+	return n.Uint64(), true
+}
+
+// tooPrecise reports whether d has more than maxPlaces decimal places
+// (counting trailing zeros, which also enlarge the knapsack table)
+// if maxPlaces is positive.
+func tooPrecise(d decimal.Decimal, maxPlaces int32) bool {
+	return maxPlaces > 0 && -d.Exponent() > maxPlaces
+}
+
+// tooLarge reports whether d exceeds max (unless max is zero).
+func tooLarge(d decimal.Decimal, max decimal.Decimal) bool {
+	return max.IsPositive() && d.GreaterThan(max)
+}
+
+// significantExponent returns the exponent of d without trailing zeros
+// (so that 13.0 and 13 both have an exponent of zero).
+func significantExponent(d decimal.Decimal) int32 {
+	exponent := d.Exponent()
+	for exponent < 0 && d.Shift(-(exponent + 1)).IsInteger() {
+		exponent++
+	}
+	return exponent
+}
+
+// GetShares converts a number of share units to a number of shares.
+func (nl *NormalizedLots) GetShares(shareUnits uint64) decimal.Decimal {
+	return decimal.New(int64(shareUnits), nl.shareExponent)
+}
+
+// LotShares returns the number of shares (or, for cash,
+// the amount) of lot's share units.
+func (nl *NormalizedLots) LotShares(lot *Lot) decimal.Decimal {
+	if lot.json.Cash {
+		return decimal.New(int64(lot.shares), nl.sharePriceExponent+nl.shareExponent)
+	}
+	return nl.GetShares(lot.shares).Add(lot.fraction.Shift(nl.shareExponent))
+}
+
+// UnitCapitalGains returns the normalized capital gains of one share unit
+// of lot.  Because normalization is exact, it is always
+// Input.UnitCapitalGains of the lot shifted by -sharePriceExponent,
+// so the optimizer and the output agree on which lots gain and lose.
+func (na *NormalizedLots) UnitCapitalGains(lot *Lot) int64 {
+	return int64(lot.price) - int64(lot.cost)
+}
+
+// Value returns the knapsack value of one share unit of lot,
+// which is its unit capital gains (or losses if maximizing losses).
+func (nl *NormalizedLots) Value(lot *Lot) int64 {
+	if nl.maximizeLosses {
+		return -nl.UnitCapitalGains(lot)
+	}
+	return nl.UnitCapitalGains(lot)
+}
+
+// score returns the knapsack value of one share unit of lot
+// that the solvers maximize: its Value
+// or, if Options.ValueFunc is set, the value that ValueFunc returns for it.
+func (nl *NormalizedLots) score(lot *Lot) int64 {
+	if nl.valueFunc == nil {
+		return nl.Value(lot)
+	}
+	return nl.valueFunc(lot.json, nl.Value(lot))
+}
+
+// checkScores returns an error if Options.ValueFunc scores
+// any of nl's lots negatively.
+func (nl *NormalizedLots) checkScores() error {
+	if nl.valueFunc == nil {
+		return nil
+	}
+	for m := range nl.lots {
+		if score := nl.score(&nl.lots[m]); score < 0 {
+			return fmt.Errorf(`value function returned a negative value for lot of %s acquired on %s: %d`, nl.lots[m].json.AssetName, nl.lots[m].json.Date, score)
+		}
+	}
+	return nil
+}
+
+// LotValue returns the total capital gains
+// (or losses if maximizing losses) of lot's donatable shares
+// in the units of the input's prices.
+func (nl *NormalizedLots) LotValue(lot *Lot) decimal.Decimal {
+	return decimal.New(nl.Value(lot), nl.sharePriceExponent+nl.shareExponent).Mul(decimal.New(int64(lot.shares), 0))
+}
+
+// ExclusionReason is the reason FilterLotsInPlace excluded a lot.
+type ExclusionReason string
+
+const (
+	// The lot's asset is in Options.ExcludeAssets.
+	ExcludedAsset ExclusionReason = "excludedAsset"
+
+	// The lot's asset is not in Options.OnlyAssets.
+	NotSelected ExclusionReason = "notSelected"
+
+	// The lot has no capital gains (when maximizing gains).
+	NoCapitalGains ExclusionReason = "noCapitalGains"
+
+	// The lot has no capital losses (when maximizing losses).
+	NoCapitalLosses ExclusionReason = "noCapitalLosses"
+
+	// The lot is short-term (when maximizing gains).
+	ShortTerm ExclusionReason = "shortTerm"
+
+	// The lot's asset has a recent purchase that would make
+	// selling it at a loss a wash sale (when maximizing losses).
+	WashSale ExclusionReason = "washSale"
+
+	// The lot has no shares to donate.
+	NoShares ExclusionReason = "noShares"
+
+	// The total capital gains (or losses when maximizing losses)
+	// of the lot's donatable shares are less than Options.MinLotGain.
+	BelowMinLotGain ExclusionReason = "belowMinLotGain"
+
+	// A single share of the lot costs more than the donation amount.
+	PriceExceedsDonation ExclusionReason = "priceExceedsDonation"
+)
+
+// ExcludedLot is a lot that FilterLotsInPlace excluded.
+type ExcludedLot struct {
+	Lot
+	Reason ExclusionReason
+}
+
+// GetExclusionReason returns the reason FilterLotsInPlace excludes lot
+// or false if it keeps lot.
+func (nl *NormalizedLots) GetExclusionReason(lot *Lot) (ExclusionReason, bool) {
+	switch {
+	case lot.json.Pinned && lot.shares == 0:
+		return NoShares, true
+	case lot.json.Pinned:
+		// Pinned lots are donated unconditionally.
+		return "", false
+	case nl.excludedAssets[lot.json.AssetName]:
+		return ExcludedAsset, true
+	case nl.onlyAssets != nil && !nl.onlyAssets[lot.json.AssetName]:
+		return NotSelected, true
+	case lot.json.Cash && lot.shares == 0:
+		return NoShares, true
+	case lot.json.Cash && lot.price > nl.donation:
+		return PriceExceedsDonation, true
+	case lot.json.Cash:
+		// Cash has no capital gains or holding period to check.
+		return "", false
+	case nl.Value(lot) <= 0 && nl.maximizeLosses:
+		return NoCapitalLosses, true
+	case nl.Value(lot) <= 0:
+		return NoCapitalGains, true
+	case !nl.maximizeLosses && !lot.longTerm && !nl.includeShortTerm:
+		return ShortTerm, true
+	case nl.maximizeLosses && nl.washSaleAssets[lot.json.AssetName]:
+		return WashSale, true
+	case lot.shares == 0:
+		return NoShares, true
+	case nl.minLotGain.IsPositive() && nl.LotValue(lot).LessThan(nl.minLotGain):
+		return BelowMinLotGain, true
+	case lot.price > nl.donation:
+		return PriceExceedsDonation, true
+	}
+	return "", false
+}
+
+// FilterLotsInPlace removes the lots that cannot be donated
+// and records them in nl.excluded.
+// Both the remaining and the excluded lots keep their order
+// (so that ties between equally good donations break
+// the same way on every run and excluded lots are in input order
+// if nl's lots are).
+func (nl *NormalizedLots) FilterLotsInPlace() {
+	kept := nl.lots[:0]
+	for _, lot := range nl.lots {
+		if reason, excluded := nl.GetExclusionReason(&lot); excluded {
+			nl.excluded = append(nl.excluded, ExcludedLot{Lot: lot, Reason: reason})
+		} else {
+			kept = append(kept, lot)
+		}
+	}
+	nl.lots = kept
+}
+
+// GetTotalPrice returns the total normalized price of all lots.
+// It returns an error if the total does not fit in a uint64.
+func (nl *NormalizedLots) GetTotalPrice() (totalPrice uint64, err error) {
+	for _, lot := range nl.lots {
+		hi, price := bits.Mul64(lot.price, lot.shares)
+		var carry uint64
+		totalPrice, carry = bits.Add64(totalPrice, price, 0)
+		if hi != 0 || carry != 0 {
+			err = fmt.Errorf(`total price overflows at lot of %s acquired on %s`, lot.json.AssetName, lot.json.Date)
+			return
+		}
+	}
+	return
+}
+
+// PartitionPinnedLots returns the pinned lots in lots (see LotJSON.Pinned)
+// and the other lots, both in their original order.
+func PartitionPinnedLots(lots []Lot) (pinned []Lot, other []Lot) {
+	for _, lot := range lots {
+		if lot.json.Pinned {
+			pinned = append(pinned, lot)
+		} else {
+			other = append(other, lot)
+		}
+	}
+	return
+}
+
+// PartitionCashLots returns the cash lots in lots
+// and the other lots, both in their original order.
+func PartitionCashLots(lots []Lot) (cash []Lot, other []Lot) {
+	for _, lot := range lots {
+		if lot.json.Cash {
+			cash = append(cash, lot)
+		} else {
+			other = append(other, lot)
+		}
+	}
+	return
+}
+
+// FillWithCash returns the share units of cash (cash lots)
+// that fill as much of budget (a normalized donation) as possible
+// in order and the budget that remains.
+// If wholeLots is set, it only takes cash lots that fit entirely.
+func FillWithCash(cash []Lot, budget uint64, wholeLots bool) (filled []Lot, remaining uint64) {
+	remaining = budget
+	for _, lot := range cash {
+		if shares := remaining / lot.price; shares > 0 && (!wholeLots || shares >= lot.shares) {
+			if shares < lot.shares {
+				lot.shares = shares
+			}
+			filled = append(filled, lot)
+			remaining -= lot.shares * lot.price
+		}
+	}
+	return
+}
+
+// PartitionFreeLots returns the lots in lots with zero normalized prices
+// and the other lots, both in their original order.
+func PartitionFreeLots(lots []Lot) (free []Lot, priced []Lot) {
+	for _, lot := range lots {
+		if lot.price == 0 {
+			free = append(free, lot)
+		} else {
+			priced = append(priced, lot)
+		}
+	}
+	return
+}
+
+// GetShareUnits returns the total number of share units in nl's lots.
+func (nl *NormalizedLots) GetShareUnits() (shareUnits uint64) {
+	for _, lot := range nl.lots {
+		shareUnits += lot.shares
+	}
+	return
+}
+
+// CheckCells returns an error if solving a knapsack problem
+// with the specified number of items and nl's normalized donation
+// requires more than maxCells cells (unless maxCells is zero).
+func (nl *NormalizedLots) CheckCells(items uint64, maxCells uint64) error {
+	if maxCells == 0 {
+		return nil
+	}
+	if hi, cells := bits.Mul64(items, nl.donation+1); hi != 0 || cells > maxCells {
+		return fmt.Errorf(`the donation requires more than %d knapsack cells (%d items times a capacity of %d); round prices, costs, the donation amount, and numbers of shares to fewer decimal places or reduce the donation amount`, maxCells, items, nl.donation)
+	}
+	return nil
+}
+
+// CapLosses returns the shares of lots with the largest unit capital losses
+// whose total capital losses first reach lossCap
+// (or all of lots if their total losses do not reach lossCap).
+// lots must all have capital losses.
+// lossCap is normalized like donation.
+// With Options.WholeLots, it keeps the last lot whole
+// even if its losses exceed lossCap.
+func (nl *NormalizedLots) CapLosses(lots []Lot, lossCap uint64) (capped []Lot) {
+	capped = append([]Lot(nil), lots...)
+	sort.SliceStable(capped, func(a, b int) bool {
+		return nl.UnitCapitalGains(&capped[a]) < nl.UnitCapitalGains(&capped[b])
+	})
+	totalLoss := uint64(0)
+	for m := range capped {
+		if totalLoss >= lossCap {
+			return capped[:m]
+		}
+		unitLoss := uint64(-nl.UnitCapitalGains(&capped[m]))
+		if neededShares := (lossCap - totalLoss + unitLoss - 1) / unitLoss; neededShares < capped[m].shares && !nl.wholeLots {
+			capped[m].shares = neededShares
+		}
+		totalLoss += unitLoss * capped[m].shares
+	}
+	return
+}
+
+// SortLots sorts lots by asset name, date, share cost,
+// and finally position in the input.
+func SortLots(lots []Lot) {
+	sort.Slice(lots, func(a, b int) bool {
+		return lotLess(lots[a].json, lots[b].json, lots[a].index, lots[b].index)
+	})
+}
+
+// lotLess reports whether SortLots puts lot x (at index a of the input)
+// before lot y (at index b).
+func lotLess(x, y *LotJSON, a, b int) bool {
+	if x.AssetName != y.AssetName {
+		return x.AssetName < y.AssetName
+	}
+	if x.Date != y.Date {
+		return x.Date < y.Date
+	}
+	if c := x.ShareCost.Cmp(y.ShareCost); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+// Cost basis methods for SortLotsByBasisMethod
+const (
+	// first in, first out: prefer the oldest lots
+	FIFO = "fifo"
+
+	// last in, first out: prefer the newest lots
+	LIFO = "lifo"
+
+	// highest in, first out: prefer the lots with the highest costs
+	HIFO = "hifo"
+
+	// lowest cost, first out: prefer the lots with the lowest costs
+	LOCO = "loco"
+)
+
+// SortLotsByBasisMethod stably sorts nl's lots so that the knapsack solvers,
+// which keep the first of equally valuable solutions they find,
+// prefer the lots that the specified cost basis method prefers.
+// An empty method keeps the input order.
+func (nl *NormalizedLots) SortLotsByBasisMethod(method string) error {
+	var less func(a, b *Lot) bool
+	switch method {
+	case "":
+		return nil
+	case FIFO:
+		less = func(a, b *Lot) bool { return a.acquired.Before(b.acquired) }
+	case LIFO:
+		less = func(a, b *Lot) bool { return a.acquired.After(b.acquired) }
+	case HIFO:
+		less = func(a, b *Lot) bool { return a.cost > b.cost }
+	case LOCO:
+		less = func(a, b *Lot) bool { return a.cost < b.cost }
+	default:
+		return fmt.Errorf(`unknown cost basis method: %s`, method)
+	}
+	sort.SliceStable(nl.lots, func(a, b int) bool { return less(&nl.lots[a], &nl.lots[b]) })
+	return nil
+}
+
+// Tie-breaking rules for SortLotsByTieBreak
+const (
+	// prefer the lots that appear earlier in the input
+	TieBreakInput = "input"
+
+	// prefer the lots with the largest capital gains
+	// (or losses when maximizing losses) per share
+	TieBreakMaxGain = "max-gain"
+
+	// prefer the oldest lots
+	TieBreakMaxAge = "max-age"
+
+	// the rule that an empty rule means
+	DefaultTieBreak = TieBreakMaxGain
+)
+
+// SortLotsByTieBreak stably sorts nl's lots so that the knapsack solvers,
+// which keep the first of equally valuable solutions they find,
+// prefer the lots that the specified tie-breaking rule prefers.
+// An empty rule means DefaultTieBreak.
+func (nl *NormalizedLots) SortLotsByTieBreak(tieBreak string) error {
+	if tieBreak == "" {
+		tieBreak = DefaultTieBreak
+	}
+	var less func(a, b *Lot) bool
+	switch tieBreak {
+	case TieBreakInput:
+		return nil
+	case TieBreakMaxGain:
+		less = func(a, b *Lot) bool { return nl.Value(a) > nl.Value(b) }
+	case TieBreakMaxAge:
+		less = func(a, b *Lot) bool { return a.acquired.Before(b.acquired) }
+	default:
+		return fmt.Errorf(`unknown tie-breaking rule: %s`, tieBreak)
+	}
+	sort.SliceStable(nl.lots, func(a, b int) bool { return less(&nl.lots[a], &nl.lots[b]) })
+	return nil
+}
+
+// ExpandLots returns a knapsack item with one share unit
+// for each share unit of unexpanded
+// or an error (see CheckItems) if that is more than maxItems items
+// (unless maxItems is zero).
+// SplitLots returns far fewer equivalent items.
+func ExpandLots(unexpanded []Lot, maxItems uint64) (expanded []Lot, err error) {
+	numShares := uint64(0)
+	for _, lot := range unexpanded {
+		var carry uint64
+		if numShares, carry = bits.Add64(numShares, lot.shares, 0); carry != 0 {
+			numShares = math.MaxUint64
+			break
+		}
+	}
+	if err = CheckItems(numShares, maxItems); err != nil {
+		return
+	}
+	expanded = make([]Lot, numShares)[:0]
+	for _, lot := range unexpanded {
+		shares := lot.shares
+		lot.shares = 1
+		for n := uint64(0); n < shares; n++ {
+			expanded = append(expanded, lot)
+		}
+	}
+	return
+}
+
+// SplitLots splits each lot in unsplit into knapsack items
+// with 1, 2, 4, ... share units and a final item with the remaining units
+// (so that every number of share units from zero to the lot's total
+// is the sum of some of the lot's items).
+// This reduces the bounded knapsack problem to a 0-1 knapsack problem
+// with O(log(shares)) items per lot instead of one item per share unit.
+func SplitLots(unsplit []Lot) (split []Lot) {
+	for _, lot := range unsplit {
+		remaining := lot.shares
+		for size := uint64(1); remaining > 0; size *= 2 {
+			if size > remaining {
+				size = remaining
+			}
+			item := lot
+			item.shares = size
+			split = append(split, item)
+			remaining -= size
+		}
+	}
+	return
+}
+
+// countSplitItems returns the number of items
+// that SplitLots would split lots into.
+func countSplitItems(lots []Lot) (items uint64) {
+	for _, lot := range lots {
+		remaining := lot.shares
+		for size := uint64(1); remaining > 0; size *= 2 {
+			if size > remaining {
+				size = remaining
+			}
+			items++
+			remaining -= size
+		}
+	}
+	return
+}
+
+// CheckItems returns an error if a knapsack problem
+// has more than maxItems items (unless maxItems is zero).
+func CheckItems(items uint64, maxItems uint64) error {
+	if maxItems != 0 && items > maxItems {
+		return fmt.Errorf(`the donation requires %d knapsack items, more than the maximum of %d; merge lots or round numbers of shares to fewer decimal places`, items, maxItems)
+	}
+	return nil
+}
+
+// ItemWeight returns the normalized price of all share units of item.
+func (nl *NormalizedLots) ItemWeight(item *Lot) uint64 {
+	return item.price * item.shares
+}
+
+// ItemValue returns the knapsack value of all share units of item
+// (see score).
+func (nl *NormalizedLots) ItemValue(item *Lot) int64 {
+	return nl.score(item) * int64(item.shares)
+}
+
+// weighByAge returns value, the Value of some of lot's share units,
+// times lot's ageFactor if nl is weighted by age (see Options.AgeWeight).
+func (nl *NormalizedLots) weighByAge(lot *Lot, value int64) int64 {
+	if !nl.ageWeighted {
+		return value
+	}
+	return value * lot.ageFactor
+}
+
+// objective returns the knapsack value that the solvers maximize
+// for share units with the specified total Value
+// (weighted by age; see weighByAge) and normalized price.
+// It is value unless maximizing efficiency, in which case
+// it is value times (normalized donation + 1) minus price
+// so that any greater total Value beats any smaller total price
+// (because no donation's total price exceeds the normalized donation).
+func (nl *NormalizedLots) objective(value int64, price uint64) int64 {
+	if !nl.efficiency {
+		return value
+	}
+	return value*int64(nl.donation+1) - int64(price)
+}
+
+// ItemObjective returns the knapsack value that the solvers maximize
+// for all share units of item (see Options.Objective).
+func (nl *NormalizedLots) ItemObjective(item *Lot) int64 {
+	return nl.objective(nl.weighByAge(item, nl.ItemValue(item)), nl.ItemWeight(item))
+}
+
+// checkObjective returns an error if the total objective of nl's lots
+// could overflow.
+func (nl *NormalizedLots) checkObjective() error {
+	if !nl.efficiency && !nl.ageWeighted && nl.valueFunc == nil {
+		return nil
+	}
+	var total uint64
+	for _, lot := range nl.lots {
+		hi, value := bits.Mul64(uint64(nl.score(&lot)), lot.shares)
+		if nl.ageWeighted && hi == 0 {
+			hi, value = bits.Mul64(value, uint64(lot.ageFactor))
+		}
+		var carry uint64
+		total, carry = bits.Add64(total, value, 0)
+		if hi != 0 || carry != 0 || ((nl.ageWeighted || nl.valueFunc != nil) && total > 1<<63-1) {
+			return fmt.Errorf(`total capital gains overflow at lot of %s acquired on %s`, lot.json.AssetName, lot.json.Date)
+		}
+	}
+	if !nl.efficiency {
+		return nil
+	}
+	if hi, scaled := bits.Mul64(total, nl.donation+1); hi != 0 || scaled > 1<<63-1 {
+		return fmt.Errorf(`the donation is too large or too precise to maximize efficiency; round prices, costs, the donation amount, and numbers of shares to fewer decimal places or use the gains objective`)
+	}
+	return nil
+}
+
+// DeduplicateLots combines the share units of the items of each lot
+// (from ExpandLots or SplitLots) into one Lot,
+// regardless of where they appear in lots.
+// The combined lots appear in the order of their first items.
+func DeduplicateLots(lots []Lot) (deduplicated []Lot) {
+	deduplicated = make([]Lot, len(lots))[:0]
+	indexes := make(map[*LotJSON]int)
+	for _, lot := range lots {
+		if index, ok := indexes[lot.json]; ok {
+			deduplicated[index].shares += lot.shares
+			continue
+		}
+		indexes[lot.json] = len(deduplicated)
+		deduplicated = append(deduplicated, lot)
+	}
+	return
+}
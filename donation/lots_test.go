@@ -0,0 +1,623 @@
+package donation
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/johnmuirjr/go-knapsack"
+	"github.com/shopspring/decimal"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizationOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    Input
+		donation string
+		want     string
+	}{
+		{"total price", testInput([]LotJSON{testLot("A", "10000000000", "1"), testLot("A", "10000000000", "2")}, "A", "1000000000000001"), "2000000000000000", "total price overflows at lot of A acquired on 2020-01-02"},
+		{"precise price", testInput([]LotJSON{testLot("A", "1", "0")}, "A", "1.0000000000000000000001"), "0.0000000000000000000001", "share price of A is too large or too precise: 1.0000000000000000000001"},
+		{"precise cost", testInput([]LotJSON{testLot("A", "1", "0.0000000000000000000001")}, "A", "2"), "1", "too large or too precise"},
+		{"precise shares", testInput([]LotJSON{testLot("A", "1.0000000000000000000001", "1")}, "A", "2"), "1", "too large or too precise"},
+		{"large donation", testInput([]LotJSON{testLot("A", "1", "1")}, "A", "2.000000001"), "100000000000000", "donation amount is too large or too precise: 100000000000000"},
+	}
+	for _, test := range tests {
+		_, err := Optimize(test.input, testOptions(test.donation))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestInvalidDonation(t *testing.T) {
+	input := testInput([]LotJSON{testLot("A", "10", "1")}, "A", "2")
+	for _, donation := range []string{"1,000", "abc", "10$", "1e", "%"} {
+		_, err := Optimize(input, testOptions(donation))
+		if want := `invalid donation amount "` + donation + `": `; err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("donation %q: error %v, want one starting with %q", donation, err, want)
+		}
+	}
+}
+
+func TestNonPositiveDonation(t *testing.T) {
+	input := testInput([]LotJSON{testLot("A", "10", "1")}, "A", "2")
+	for _, donation := range []string{"0", "0.00", "-500", "-0.01", "0%", "-5%"} {
+		_, err := Optimize(input, testOptions(donation))
+		if want := "donation amount must be positive: " + donation; err == nil || err.Error() != want {
+			t.Errorf("donation %q: error %v, want %q", donation, err, want)
+		}
+	}
+}
+
+func TestNonPositiveShares(t *testing.T) {
+	for _, shares := range []string{"0", "-3", "-0.5"} {
+		var input Input
+		data := `{"assetSharePrices": {"A": 2, "B": 2}, "lots": [
+			{"assetName": "A", "date": "2020-01-02", "shares": 10, "shareCost": 1},
+			{"assetName": "B", "date": "2020-01-02", "shares": ` + shares + `, "shareCost": 1}]}`
+		if err := json.Unmarshal([]byte(data), &input); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Optimize(input, testOptions("10"))
+		if want := "lot of B acquired on 2020-01-02 must have a positive number of shares: " + shares; err == nil || err.Error() != want {
+			t.Errorf("shares %s: error %v, want %q", shares, err, want)
+		}
+	}
+}
+
+func TestDeduplicateLots(t *testing.T) {
+	jsons := []LotJSON{testLot("A", "5", "1"), testLot("B", "7", "1"), testLot("C", "2", "1")}
+	a, b, c := Lot{json: &jsons[0], index: 0}, Lot{json: &jsons[1], index: 1}, Lot{json: &jsons[2], index: 2}
+	item := func(lot Lot, shares uint64) Lot {
+		lot.shares = shares
+		return lot
+	}
+	tests := []struct {
+		name  string
+		items []Lot
+		want  []Lot
+	}{
+		{"empty", nil, []Lot{}},
+		{"contiguous", []Lot{item(a, 1), item(a, 2), item(b, 1)}, []Lot{item(a, 3), item(b, 1)}},
+		{"interleaved", []Lot{item(a, 1), item(b, 1), item(a, 2), item(c, 2), item(b, 2), item(a, 2), item(b, 4)}, []Lot{item(a, 5), item(b, 7), item(c, 2)}},
+		{"order of first items", []Lot{item(c, 1), item(a, 1), item(c, 1), item(b, 1)}, []Lot{item(c, 2), item(a, 1), item(b, 1)}},
+		{"expanded", []Lot{item(b, 1), item(a, 1), item(b, 1), item(a, 1), item(b, 1)}, []Lot{item(b, 3), item(a, 2)}},
+	}
+	for _, test := range tests {
+		got := DeduplicateLots(test.items)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: %d lots, want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for m := range got {
+			if got[m].json != test.want[m].json || got[m].shares != test.want[m].shares {
+				t.Errorf("%s: lot %d is %s with %d share units, want %s with %d", test.name, m, got[m].json.AssetName, got[m].shares, test.want[m].json.AssetName, test.want[m].shares)
+			}
+		}
+	}
+}
+
+func TestNegativePrices(t *testing.T) {
+	price := func(p string) *decimal.Decimal {
+		d := decimal.RequireFromString(p)
+		return &d
+	}
+	withSharePrice := testLot("A", "1", "1")
+	withSharePrice.SharePrice = price("-1")
+	withZeroSharePrice := testLot("A", "1", "0")
+	withZeroSharePrice.SharePrice = price("0")
+	tests := []struct {
+		name  string
+		input Input
+		want  string
+	}{
+		{"negative share price", testInput([]LotJSON{testLot("A", "1", "1")}, "A", "2", "B", "-0.01"), "share price of B must not be negative: -0.01"},
+		{"negative cost", testInput([]LotJSON{testLot("A", "1", "1"), testLot("B", "2", "-3")}, "A", "2", "B", "2"), "lot of B acquired on 2020-01-02 must not have a negative shareCost: -3"},
+		{"negative lot share price", testInput([]LotJSON{withSharePrice}, "A", "2"), "lot of A acquired on 2020-01-02 must not have a negative sharePrice: -1"},
+		{"zero price and cost", testInput([]LotJSON{testLot("A", "1", "0"), testLot("B", "1", "0")}, "A", "0", "B", "2"), ""},
+		{"zero lot share price", testInput([]LotJSON{withZeroSharePrice}, "A", "2"), ""},
+	}
+	for _, test := range tests {
+		_, err := Optimize(test.input, testOptions("10"))
+		if test.want == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if test.want != "" && (err == nil || err.Error() != test.want) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestOnlyAssets(t *testing.T) {
+	input := testInput([]LotJSON{testLot("A", "1", "1"), testLot("B", "1", "1"), testLot("C", "1", "1")}, "A", "2", "B", "2", "C", "2")
+	tests := []struct {
+		name           string
+		only, exclude  []string
+		wantDonated    string
+		wantExclusions map[string]ExclusionReason
+	}{
+		{"all assets", nil, nil, "ABC", map[string]ExclusionReason{}},
+		{"empty whitelist", []string{}, nil, "", map[string]ExclusionReason{"A": NotSelected, "B": NotSelected, "C": NotSelected}},
+		{"whitelist", []string{"A", "C"}, nil, "AC", map[string]ExclusionReason{"B": NotSelected}},
+		{"unknown asset", []string{"D"}, nil, "", map[string]ExclusionReason{"A": NotSelected, "B": NotSelected, "C": NotSelected}},
+		{"exclude wins", []string{"A", "B"}, []string{"B"}, "A", map[string]ExclusionReason{"B": ExcludedAsset, "C": NotSelected}},
+		{"exclude everything allowed", []string{"A"}, []string{"A"}, "", map[string]ExclusionReason{"A": ExcludedAsset, "B": NotSelected, "C": NotSelected}},
+	}
+	for _, test := range tests {
+		opts := testOptions("10")
+		opts.OnlyAssets, opts.ExcludeAssets = test.only, test.exclude
+		output, err := Optimize(input, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		donated := ""
+		for _, lot := range output.Lots {
+			donated += lot.AssetName
+		}
+		exclusions := make(map[string]ExclusionReason)
+		for _, lot := range output.ExcludedLots {
+			exclusions[lot.AssetName] = lot.Reason
+		}
+		if donated != test.wantDonated || !reflect.DeepEqual(exclusions, test.wantExclusions) {
+			t.Errorf("%s: donated %q and excluded %v, want %q and %v", test.name, donated, exclusions, test.wantDonated, test.wantExclusions)
+		}
+	}
+}
+
+func TestEfficiencyObjective(t *testing.T) {
+	tests := []struct {
+		name                string
+		input               Input
+		donation            string
+		objective           string
+		wantValue, wantGain string
+	}{
+		// Both lots have the same gains, but A costs less to donate.
+		{"equal gains", testInput([]LotJSON{testLot("B", "1", "5"), testLot("A", "1", "0")}, "A", "10", "B", "15"), "15", ObjectiveGains, "15", "10"},
+		{"equal gains", testInput([]LotJSON{testLot("B", "1", "5"), testLot("A", "1", "0")}, "A", "10", "B", "15"), "15", ObjectiveEfficiency, "10", "10"},
+		// Efficiency never gives up gains to save money.
+		{"unequal gains", testInput([]LotJSON{testLot("B", "1", "4"), testLot("A", "1", "0")}, "A", "10", "B", "15"), "15", ObjectiveEfficiency, "15", "11"},
+		// Shares of B cost less for the same gains as the share of C.
+		{"split lots", testInput([]LotJSON{testLot("C", "1", "10"), testLot("B", "4", "1.5")}, "B", "4", "C", "20"), "20", ObjectiveGains, "20", "10"},
+		{"split lots", testInput([]LotJSON{testLot("C", "1", "10"), testLot("B", "4", "1.5")}, "B", "4", "C", "20"), "20", ObjectiveEfficiency, "16", "10"},
+	}
+	for _, test := range tests {
+		opts := testOptions(test.donation)
+		opts.Objective = test.objective
+		output, err := Optimize(test.input, opts)
+		if err != nil {
+			t.Fatalf("%s, %s: %v", test.name, test.objective, err)
+		}
+		if output.TotalValue.String() != test.wantValue || output.TotalCapitalGains.String() != test.wantGain {
+			t.Errorf("%s, %s: total value %s and capital gains %s, want %s and %s", test.name, test.objective, output.TotalValue, output.TotalCapitalGains, test.wantValue, test.wantGain)
+		}
+	}
+}
+
+func TestFilterLotsInPlaceKeepsOrder(t *testing.T) {
+	// The lots of B have no gains.
+	input := testInput([]LotJSON{
+		testLot("B", "1", "5"), testLot("A", "1", "1"), testLot("A", "2", "1"), testLot("B", "2", "5"),
+		testLot("A", "3", "1"), testLot("B", "3", "5"), testLot("A", "4", "1"),
+	}, "A", "2", "B", "2")
+	opts := testOptions("10")
+	nl, err := NewNormalizedLots(&input, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nl.FilterLotsInPlace()
+	var kept, excluded []int
+	for _, lot := range nl.lots {
+		kept = append(kept, lot.index)
+	}
+	for _, lot := range nl.excluded {
+		excluded = append(excluded, lot.index)
+	}
+	if want := []int{1, 2, 4, 6}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept lots %v, want %v", kept, want)
+	}
+	if want := []int{0, 3, 5}; !reflect.DeepEqual(excluded, want) {
+		t.Errorf("excluded lots %v, want %v", excluded, want)
+	}
+}
+
+func TestGainsAgreeWithOutput(t *testing.T) {
+	// The costs differ from the price in the last digit.
+	lots := []LotJSON{testLot("A", "3", "10.0000"), testLot("A", "3", "10.0002"), testLot("A", "3", "10.0001"), testLot("A", "3", "10.00009"), testLot("A", "3", "10.000101")}
+	for _, maximizeLosses := range []bool{false, true} {
+		input := testInput(lots, "A", "10.0001")
+		opts := testOptions("100")
+		opts.MaximizeLosses = maximizeLosses
+		output, err := Optimize(input, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var donated []string
+		for _, lot := range output.Lots {
+			donated = append(donated, lot.ShareCost.String())
+			if gains := lot.CapitalGains; gains.IsZero() || gains.IsNegative() != maximizeLosses {
+				t.Errorf("maximize losses %v: donated lot with share cost %s has capital gains %s", maximizeLosses, lot.ShareCost, gains)
+			}
+		}
+		want := []string{"10", "10.00009"}
+		if maximizeLosses {
+			want = []string{"10.0002", "10.000101"}
+		}
+		if !reflect.DeepEqual(donated, want) {
+			t.Errorf("maximize losses %v: donated lots with share costs %v, want %v", maximizeLosses, donated, want)
+		}
+	}
+}
+
+func TestShiftToInteger(t *testing.T) {
+	tests := []struct {
+		d        string
+		exponent int32
+		want     uint64
+		wantOK   bool
+	}{
+		{"10.0001", -4, 100001, true},
+		{"10.0001", -3, 0, false},
+		{"10.00015", -4, 0, false},
+		{"10.9999", -3, 0, false},
+		{"1200", 2, 12, true},
+		{"1250", 2, 0, false},
+		{"-0.01", -2, 0, false},
+		{"9223372036854775807", 0, 9223372036854775807, true},
+		{"9223372036854775808", 0, 0, false},
+	}
+	for _, test := range tests {
+		got, ok := ShiftToInteger(decimal.RequireFromString(test.d), test.exponent)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("ShiftToInteger(%s, %d) = %d, %v, want %d, %v", test.d, test.exponent, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestNormalizationExponents(t *testing.T) {
+	decimalPointer := func(d string) *decimal.Decimal {
+		value := decimal.RequireFromString(d)
+		return &value
+	}
+	cash := LotJSON{AssetName: "USD", Date: "2020-01-02", Shares: decimal.RequireFromString("12.340"), Cash: true}
+	fractional := testLot("A", "2.50", "1")
+	limited := testLot("A", "10", "1")
+	limited.MaxDonatableShares = decimalPointer("0.125")
+	priced := testLot("B", "1", "1")
+	priced.SharePrice = decimalPointer("3.14159")
+	tests := []struct {
+		name                                      string
+		input                                     Input
+		donation                                  string
+		wantSharePriceExponent, wantShareExponent int32
+	}{
+		{"example", testInput([]LotJSON{testLot("A", "13", "50.55"), testLot("B", "50", "10.00")}, "A", "100.22", "B", "12.35"), "1000.00", -2, 0},
+		{"mixed precision", testInput([]LotJSON{testLot("A", "1", "10.125")}, "A", "1.5"), "1000", -3, 0},
+		{"precise price", testInput([]LotJSON{testLot("A", "1", "10")}, "A", "1.00001"), "1000", -5, 0},
+		{"donation's exponent", testInput([]LotJSON{testLot("A", "1", "10.5")}, "A", "12"), "1000.0000", -4, 0},
+		{"trailing zeros", testInput([]LotJSON{testLot("A", "1", "10.500")}, "A", "12"), "1", -3, 0},
+		{"integers", testInput([]LotJSON{testLot("A", "100", "1200")}, "A", "1300"), "5000", 0, 0},
+		{"no donation", testInput([]LotJSON{testLot("A", "100", "1200")}, "A", "1300"), "", 0, 0},
+		{"positive donation exponent", testInput([]LotJSON{testLot("A", "1", "12")}, "A", "13"), "1e3", 0, 0},
+		{"fractional shares", testInput([]LotJSON{fractional}, "A", "2"), "10", 0, -1},
+		{"maxDonatableShares", testInput([]LotJSON{limited}, "A", "2"), "10", 0, -3},
+		{"lot share price", testInput([]LotJSON{priced}, "B", "2"), "10", -5, 0},
+		{"cash", testInput([]LotJSON{cash, testLot("A", "1", "1")}, "A", "2"), "10", -2, 0},
+	}
+	for _, test := range tests {
+		var donation *decimal.Decimal
+		if test.donation != "" {
+			donation = decimalPointer(test.donation)
+		}
+		sharePriceExponent, shareExponent := NormalizationExponents(&test.input, donation)
+		if sharePriceExponent != test.wantSharePriceExponent || shareExponent != test.wantShareExponent {
+			t.Errorf("%s: exponents %d and %d, want %d and %d", test.name, sharePriceExponent, shareExponent, test.wantSharePriceExponent, test.wantShareExponent)
+		}
+	}
+}
+
+func TestTieBreak(t *testing.T) {
+	// Donating all of any one lot captures the same gains with the same value.
+	y, x, z := testLot("Y", "2", "0"), testLot("X", "1", "0"), testLot("Z", "5", "0")
+	y.Date, x.Date, z.Date = "2018-01-02", "2020-01-02", "2015-01-02"
+	tests := []struct {
+		tieBreak string
+		want     string
+	}{
+		{"", "X"},
+		{DefaultTieBreak, "X"},
+		{TieBreakMaxGain, "X"},
+		{TieBreakMaxAge, "Z"},
+		{TieBreakInput, "Y"},
+	}
+	for _, test := range tests {
+		for _, wholeLots := range []bool{false, true} {
+			input := testInput([]LotJSON{y, x, z}, "X", "10", "Y", "5", "Z", "2")
+			opts := testOptions("10")
+			opts.TieBreak, opts.WholeLots = test.tieBreak, wholeLots
+			output, err := Optimize(input, opts)
+			if err != nil {
+				t.Fatalf("tie-break %q: %v", test.tieBreak, err)
+			}
+			donated := ""
+			for _, lot := range output.Lots {
+				donated += lot.AssetName
+			}
+			if donated != test.want || !output.TotalCapitalGains.Equal(decimal.NewFromInt(10)) {
+				t.Errorf("tie-break %q, whole lots %v: donated %q with gains %s, want %q with gains 10", test.tieBreak, wholeLots, donated, output.TotalCapitalGains, test.want)
+			}
+		}
+	}
+}
+
+func TestMaxAmountAndShares(t *testing.T) {
+	const max = "1000000000000"
+	const above = "1000000000000.01"
+	cash := func(amount string) LotJSON {
+		return LotJSON{AssetName: "USD", Date: "2020-01-02", Shares: decimal.RequireFromString(amount), Cash: true}
+	}
+	tests := []struct {
+		name     string
+		input    Input
+		donation string
+		want     string
+	}{
+		{"price at the maximum", testInput([]LotJSON{testLot("A", "1", "1")}, "A", max), "10", ""},
+		{"price above the maximum", testInput([]LotJSON{testLot("A", "1", "1")}, "A", above), "10", "share price of A is greater than the maximum of 1000000000000: 1000000000000.01; check it"},
+		{"cost at the maximum", testInput([]LotJSON{testLot("A", "1", max)}, "A", "1"), "10", ""},
+		{"cost above the maximum", testInput([]LotJSON{testLot("A", "1", above)}, "A", "1"), "10", "lot of A acquired on 2020-01-02 has a shareCost greater than the maximum of 1000000000000: 1000000000000.01; check it"},
+		{"shares at the maximum", testInput([]LotJSON{testLot("A", max, "1")}, "A", "2"), "10", ""},
+		{"shares above the maximum", testInput([]LotJSON{testLot("A", "1000000000001", "1")}, "A", "2"), "10", "lot of A acquired on 2020-01-02 has more than the maximum of 1000000000000 shares: 1000000000001; check it"},
+		{"cash at the maximum", testInput([]LotJSON{cash(max)}), "10", ""},
+		{"cash above the maximum", testInput([]LotJSON{cash(above)}), "10", "cash lot USD acquired on 2020-01-02 exceeds the maximum amount of 1000000000000: 1000000000000.01; check it"},
+		{"donation at the maximum", testInput([]LotJSON{testLot("A", "1", "1")}, "A", "2"), max, ""},
+		{"donation above the maximum", testInput([]LotJSON{testLot("A", "1", "1")}, "A", "2"), above, "donation amount exceeds the maximum of 1000000000000: 1000000000000.01; check it"},
+	}
+	for _, test := range tests {
+		opts := testOptions(test.donation)
+		opts.MaxAmount, opts.MaxShares = decimal.RequireFromString(max), decimal.RequireFromString(max)
+		_, err := Optimize(test.input, opts)
+		if test.want == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if test.want != "" && (err == nil || err.Error() != test.want) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestMaxPricePrecision(t *testing.T) {
+	const cost = "1.000000000000000001"
+	lotPrice := testLot("A", "1", "1")
+	price := decimal.RequireFromString("2.125")
+	lotPrice.SharePrice = &price
+	tests := []struct {
+		name         string
+		input        Input
+		maxPrecision int32
+		want         string
+	}{
+		{"no maximum", testInput([]LotJSON{testLot("A", "1", cost)}, "A", "1.5"), 0, ""},
+		{"cost at the maximum", testInput([]LotJSON{testLot("A", "1", cost)}, "A", "1.5"), 18, ""},
+		{"cost above the maximum", testInput([]LotJSON{testLot("A", "1", cost)}, "A", "1.5"), 17, "lot of A acquired on 2020-01-02 has a shareCost with more than 17 decimal places: 1.000000000000000001; round it"},
+		{"price at the maximum", testInput([]LotJSON{testLot("A", "1", "1")}, "A", "2.125"), 3, ""},
+		{"price above the maximum", testInput([]LotJSON{testLot("A", "1", "1")}, "A", "2.125"), 2, "share price of A has more than 2 decimal places: 2.125; round it"},
+		{"lot's price at the maximum", testInput([]LotJSON{lotPrice}, "A", "2"), 3, ""},
+		{"lot's price above the maximum", testInput([]LotJSON{lotPrice}, "A", "2"), 2, "lot of A acquired on 2020-01-02 has a sharePrice with more than 2 decimal places: 2.125; round it"},
+	}
+	for _, test := range tests {
+		opts := testOptions("5")
+		opts.MaxPricePrecision = test.maxPrecision
+		_, err := Optimize(test.input, opts)
+		if test.want == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if test.want != "" && (err == nil || err.Error() != test.want) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestMaxAmountPercentage(t *testing.T) {
+	tests := []struct {
+		price string
+		want  string
+	}{
+		{"4", ""},
+		{"5", ""},
+		{"5.01", "donation amount 50% of the total value of all lots exceeds the maximum of 1000000000000: 1002000000000; check it"},
+	}
+	for _, test := range tests {
+		input := testInput([]LotJSON{testLot("A", "400000000000", "1")}, "A", test.price)
+		opts := testOptions("50%")
+		opts.MaxAmount = decimal.RequireFromString("1000000000000")
+		// NewNormalizedLots resolves the percentage
+		// without building a huge knapsack table.
+		_, err := NewNormalizedLots(&input, &opts)
+		if test.want == "" && err != nil {
+			t.Errorf("price %s: %v", test.price, err)
+		} else if test.want != "" && (err == nil || err.Error() != test.want) {
+			t.Errorf("price %s: error %v, want %q", test.price, err, test.want)
+		}
+	}
+}
+
+func TestAgeWeight(t *testing.T) {
+	// As of testAsOf, old has been held for ten years and young for one month
+	// more than the year that makes it long-term.
+	old, young := testLot("OLD", "1", "900"), testLot("YOUNG", "1", "900")
+	old.Date, young.Date = "2014-01-01", "2022-12-01"
+	tests := []struct {
+		name        string
+		youngCost   string
+		ageWeight   string
+		wantDonated string
+	}{
+		{"no weight", "899.2", "0", "YOUNG"},
+		{"slightly larger gains", "899.2", "0.001", "OLD"},
+		{"equal gains", "900", "0.001", "OLD"},
+		{"materially larger gains", "899", "0.001", "YOUNG"},
+		{"heavier weight", "899", "0.01", "OLD"},
+		{"much larger gains", "850", "0.01", "YOUNG"},
+	}
+	for _, test := range tests {
+		young.ShareCost = decimal.RequireFromString(test.youngCost)
+		input := testInput([]LotJSON{young, old}, "OLD", "1000", "YOUNG", "1000")
+		opts := testOptions("1000")
+		opts.AgeWeight = decimal.RequireFromString(test.ageWeight)
+		output, err := Optimize(input, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(output.Lots) != 1 || output.Lots[0].AssetName != test.wantDonated {
+			t.Errorf("%s: donated %v, want %s", test.name, output.Lots, test.wantDonated)
+		}
+	}
+}
+
+func TestMaxItems(t *testing.T) {
+	jsons := []LotJSON{testLot("A", "10", "1"), testLot("B", "7", "1")}
+	lots := []Lot{{json: &jsons[0], shares: 10}, {json: &jsons[1], index: 1, shares: 7}}
+	tests := []struct {
+		maxItems uint64
+		wantErr  bool
+	}{
+		{0, false},
+		{16, true},
+		{17, false},
+		{18, false},
+	}
+	for _, test := range tests {
+		expanded, err := ExpandLots(lots, test.maxItems)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ExpandLots with maximum %d: error %v", test.maxItems, err)
+		}
+		if err == nil && len(expanded) != 17 {
+			t.Errorf("ExpandLots with maximum %d: %d items, want 17", test.maxItems, len(expanded))
+		}
+	}
+	// SplitLots splits 10 share units into 1, 2, 4, and 3 and 7 into 1, 2, and 4.
+	if items, split := countSplitItems(lots), SplitLots(lots); items != 7 || len(split) != 7 {
+		t.Errorf("%d split items, and SplitLots split the lots into %d, want 7", items, len(split))
+	}
+
+	input := testInput(jsons, "A", "2", "B", "2")
+	for _, wholeLots := range []bool{false, true} {
+		limit := uint64(7)
+		if wholeLots {
+			limit = 2
+		}
+		for _, maxItems := range []uint64{limit - 1, limit} {
+			opts := testOptions("20")
+			opts.WholeLots, opts.MaxItems = wholeLots, maxItems
+			_, err := Optimize(input, opts)
+			want := ""
+			if maxItems < limit {
+				want = fmt.Sprintf("the donation requires %d knapsack items, more than the maximum of %d; merge lots or round numbers of shares to fewer decimal places", limit, maxItems)
+			}
+			if (want == "" && err != nil) || (want != "" && (err == nil || err.Error() != want)) {
+				t.Errorf("whole lots %v, maximum %d: error %v, want %q", wholeLots, maxItems, err, want)
+			}
+		}
+	}
+}
+
+func TestValueFunc(t *testing.T) {
+	input := func() Input {
+		return testInput([]LotJSON{testLot("OIL", "1", "10"), testLot("GREEN", "1", "97.65")}, "OIL", "100", "GREEN", "100")
+	}
+	tests := []struct {
+		name        string
+		valueFunc   func(lot *LotJSON, value int64) int64
+		wantDonated string
+		wantGains   string
+		wantErr     string
+	}{
+		{"default", nil, "OIL", "90", ""},
+		{"identity", func(lot *LotJSON, value int64) int64 { return value }, "OIL", "90", ""},
+		{"excluded asset", func(lot *LotJSON, value int64) int64 {
+			if lot.AssetName == "OIL" {
+				return 0
+			}
+			return value
+		}, "GREEN", "2.35", ""},
+		{"weighted asset", func(lot *LotJSON, value int64) int64 {
+			if lot.AssetName == "GREEN" {
+				return value * 100
+			}
+			return value
+		}, "GREEN", "2.35", ""},
+		{"negative", func(lot *LotJSON, value int64) int64 { return -value }, "", "", "value function returned a negative value for lot of OIL acquired on 2020-01-02: -9000"},
+	}
+	for _, test := range tests {
+		opts := testOptions("150")
+		values := make(map[string]int64)
+		if test.valueFunc != nil {
+			opts.ValueFunc = func(lot *LotJSON, value int64) int64 {
+				values[lot.AssetName] = value
+				return test.valueFunc(lot, value)
+			}
+		}
+		output, err := Optimize(input(), opts)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(output.Lots) != 1 || output.Lots[0].AssetName != test.wantDonated || output.TotalCapitalGains.String() != test.wantGains {
+			t.Errorf("%s: donated %d lots with gains %s, want %s with gains %s", test.name, len(output.Lots), output.TotalCapitalGains, test.wantDonated, test.wantGains)
+		}
+		// The values are unit capital gains in cents, the precision of the input.
+		if want := map[string]int64{"OIL": 9000, "GREEN": 235}; test.valueFunc != nil && !reflect.DeepEqual(values, want) {
+			t.Errorf("%s: ValueFunc received %v, want %v", test.name, values, want)
+		}
+	}
+}
+
+// splitGenerate generates lots of up to hundreds of shares,
+// which ExpandLots expands into one item per share.
+var splitGenerate = GenerateOptions{Seed: 1, Assets: 4, LotsPerAsset: 5, MaxShares: 200, PriceDecimals: 1}
+
+const splitDonation = "2000"
+
+func TestSplitLotsMatchesExpandLots(t *testing.T) {
+	nl, split := benchmarkLots(t, splitGenerate, splitDonation)
+	expanded, err := ExpandLots(nl.lots, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(split) >= len(expanded) {
+		t.Fatalf("%d split items, want fewer than the %d expanded items", len(split), len(expanded))
+	}
+	splitLots := DeduplicateLots(knapsack.Get01Solution(nl.donation, split, nl.ItemWeight, nl.ItemObjective))
+	expandedLots := DeduplicateLots(knapsack.Get01Solution(nl.donation, expanded, nl.ItemWeight, nl.ItemObjective))
+	if !reflect.DeepEqual(splitLots, expandedLots) {
+		t.Errorf("split donation %v differs from the expanded donation %v", splitLots, expandedLots)
+	}
+}
+
+func BenchmarkSplitLots(b *testing.B) {
+	nl, _ := benchmarkLots(b, splitGenerate, splitDonation)
+	tests := []struct {
+		name  string
+		items func() ([]Lot, error)
+	}{
+		{"split", func() ([]Lot, error) { return SplitLots(nl.lots), nil }},
+		{"expanded", func() ([]Lot, error) { return ExpandLots(nl.lots, 0) }},
+	}
+	for _, test := range tests {
+		b.Run(test.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				items, err := test.items()
+				if err != nil {
+					b.Fatal(err)
+				}
+				knapsack.Get01Solution(nl.donation, items, nl.ItemWeight, nl.ItemObjective)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package donation
+
+import (
+	"fmt"
+)
+
+// capLots returns the best donation of nl's lots
+// with at most opts.MaxLots lots (see lotSolver)
+// and whether that donation is approximate (see chooseLotsWithin).
+// It leaves nl.maxLots set so that later solutions
+// (like FindAlternatives') have at most opts.MaxLots lots too.
+// It returns an error if nl has more than opts.MaxLots pinned lots.
+func (nl *NormalizedLots) capLots(opts *Options) (lots []Lot, approximate bool, err error) {
+	if pinnedLots, _ := PartitionPinnedLots(nl.lots); len(pinnedLots) > opts.MaxLots {
+		err = fmt.Errorf(`pinned lots exceed the maximum of %d lots`, opts.MaxLots)
+		return
+	}
+	nl.maxLots = opts.MaxLots
+	return nl.chooseLotsWithin(opts)
+}
@@ -0,0 +1,67 @@
+package donation
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestMaxLotsMatchesBruteForce(t *testing.T) {
+	tests := []struct {
+		seed      int64
+		donation  string
+		maxLots   int
+		wholeLots bool
+	}{
+		{1, "1500", 1, false},
+		{1, "1500", 2, false},
+		{2, "2500", 1, false},
+		{2, "2500", 3, false},
+		{3, "4000", 2, false},
+		{3, "4000", 2, true},
+		{4, "800", 1, true},
+	}
+	for _, test := range tests {
+		input := GenerateInput(GenerateOptions{Seed: test.seed, Assets: 3, LotsPerAsset: 2, MaxShares: 6})
+		opts := testOptions(test.donation)
+		opts.WholeLots = test.wholeLots
+		best := bruteForceMaxLots(t, input, opts, test.maxLots)
+		opts.MaxLots = test.maxLots
+		output, err := Optimize(input, opts)
+		if err != nil {
+			t.Fatalf("seed %d: %v", test.seed, err)
+		}
+		if len(output.Lots) > test.maxLots {
+			t.Errorf("seed %d, donation %s, max lots %d: donated %d lots", test.seed, test.donation, test.maxLots, len(output.Lots))
+		}
+		if !output.TotalCapitalGains.Equal(best) {
+			t.Errorf("seed %d, donation %s, max lots %d: total capital gains %s, want %s", test.seed, test.donation, test.maxLots, output.TotalCapitalGains, best)
+		}
+	}
+}
+
+// bruteForceMaxLots returns the greatest total capital gains
+// of the best donations of input's lots with opts
+// among every set of at most maxLots of the lots.
+func bruteForceMaxLots(t *testing.T, input Input, opts Options, maxLots int) (best decimal.Decimal) {
+	t.Helper()
+	lots := input.Lots
+	for set := 1; set < 1<<len(lots); set++ {
+		input.Lots = nil
+		for m := range lots {
+			if set&(1<<m) != 0 {
+				input.Lots = append(input.Lots, lots[m])
+			}
+		}
+		if len(input.Lots) > maxLots {
+			continue
+		}
+		output, err := Optimize(input, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output.TotalCapitalGains.GreaterThan(best) {
+			best = output.TotalCapitalGains
+		}
+	}
+	return
+}
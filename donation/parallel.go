@@ -0,0 +1,84 @@
+package donation
+
+import (
+	"sync"
+)
+
+// minParallelCells is the number of knapsack cells (items * capacity)
+// below which Parallel01Solution does not bother with goroutines.
+const minParallelCells = 1 << 20
+
+// Parallel01Solution solves the same 0-1 knapsack problem
+// as knapsack.Get01Solution over the items from ExpandLots or SplitLots
+// (with nl's normalized donation, ItemWeight, and ItemObjective)
+// and returns the same selection in the same order.
+// It splits the capacities of each item among up to workers goroutines.
+// It returns nil early if nl's context is done (see NormalizedLots.ctx).
+//
+// This function runs in O(i*d/workers) time (plus synchronization)
+// and uses O(d + i*d/64) space, where i is the number of items.
+func (nl *NormalizedLots) Parallel01Solution(items []Lot, workers int) (selection []Lot) {
+	capacity := nl.donation
+	if workers < 1 || uint64(len(items))*(capacity+1) < minParallelCells {
+		workers = 1
+	}
+
+	// Each worker handles whole words of the choice bit sets
+	// so that no two workers write the same word.
+	words := capacity/64 + 1
+	chunkWords := (words + uint64(workers) - 1) / uint64(workers)
+
+	previous := make([]int64, capacity+1)
+	next := make([]int64, capacity+1)
+
+	// chosen[m] has bit c set if item m is in the best solution
+	// of items 0..m at capacity c.
+	chosen := make([][]uint64, len(items))
+	var wg sync.WaitGroup
+	for m := range items {
+		if nl.canceled() {
+			return nil
+		}
+		weight := nl.ItemWeight(&items[m])
+		value := nl.ItemObjective(&items[m])
+		chosen[m] = make([]uint64, words)
+		fill := func(start, end uint64) {
+			defer wg.Done()
+			for c := start; c < end; c++ {
+				next[c] = previous[c]
+				if c >= weight && previous[c-weight]+value > previous[c] {
+					next[c] = previous[c-weight] + value
+					chosen[m][c/64] |= 1 << (c % 64)
+				}
+			}
+		}
+		for start := uint64(0); start <= capacity; start += chunkWords * 64 {
+			end := start + chunkWords*64
+			if end > capacity+1 {
+				end = capacity + 1
+			}
+			wg.Add(1)
+			if workers == 1 {
+				fill(start, end)
+			} else {
+				go fill(start, end)
+			}
+		}
+		wg.Wait()
+		previous, next = next, previous
+		nl.reportProgress(uint64(m+1)*(capacity+1), uint64(len(items))*(capacity+1))
+	}
+
+	// Reconstruct the solution from the last item to the first.
+	c := capacity
+	for m := len(items) - 1; m >= 0; m-- {
+		if chosen[m][c/64]&(1<<(c%64)) != 0 {
+			selection = append(selection, items[m])
+			c -= nl.ItemWeight(&items[m])
+		}
+	}
+	for a, b := 0, len(selection)-1; a < b; a, b = a+1, b-1 {
+		selection[a], selection[b] = selection[b], selection[a]
+	}
+	return
+}
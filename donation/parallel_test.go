@@ -0,0 +1,43 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/johnmuirjr/go-knapsack"
+	"reflect"
+	"testing"
+)
+
+// parallelGenerate generates a problem with more than minParallelCells cells
+// for a donation of parallelDonation.
+var parallelGenerate = GenerateOptions{Seed: 1, Assets: 8, LotsPerAsset: 10, MaxShares: 100, PriceDecimals: 1}
+
+const parallelDonation = "5000"
+
+func TestParallel01Solution(t *testing.T) {
+	nl, items := benchmarkLots(t, parallelGenerate, parallelDonation)
+	if cells := uint64(len(items)) * (nl.donation + 1); cells < minParallelCells {
+		t.Fatalf("%d cells, want at least %d", cells, minParallelCells)
+	}
+	want := knapsack.Get01Solution(nl.donation, items, nl.ItemWeight, nl.ItemObjective)
+	for _, workers := range []int{0, 1, 2, 3, 8} {
+		if selection := nl.Parallel01Solution(items, workers); !reflect.DeepEqual(selection, want) {
+			t.Errorf("%d workers: selection of %d items differs from knapsack.Get01Solution's %d", workers, len(selection), len(want))
+		}
+	}
+}
+
+func BenchmarkParallel01Solution(b *testing.B) {
+	nl, items := benchmarkLots(b, parallelGenerate, parallelDonation)
+	b.Run("serial", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			knapsack.Get01Solution(nl.donation, items, nl.ItemWeight, nl.ItemObjective)
+		}
+	})
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				nl.Parallel01Solution(items, workers)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+// PriorDonation is a number of shares of a lot
+// that were already donated (see Input.AlreadyDonated).
+type PriorDonation struct {
+	AssetName string          `json:"assetName"`
+	Date      string          `json:"date"`
+	Shares    decimal.Decimal `json:"shares"`
+
+	// the lot's share cost if it is needed to tell the lot apart
+	// from others of the same asset with the same date
+	ShareCost *decimal.Decimal `json:"shareCost,omitempty"`
+}
+
+// PriorTotals are the totals of the prior donations
+// (at current prices) and the donation together.
+type PriorTotals struct {
+	TotalValue        decimal.Decimal `json:"totalValue"`
+	TotalCapitalGains decimal.Decimal `json:"totalCapitalGains"`
+}
+
+// matches reports whether prior donated shares of lot.
+func (prior *PriorDonation) matches(lot *LotJSON) bool {
+	return lot.AssetName == prior.AssetName && lot.Date == prior.Date &&
+		(prior.ShareCost == nil || lot.ShareCost.Equal(*prior.ShareCost))
+}
+
+// subtractPriorDonations removes the shares in input.AlreadyDonated
+// from input's lots (taking each prior donation from the first lots
+// that it matches), drops the lots that have no shares left,
+// and returns the total value and capital gains of the removed shares
+// at current prices.
+// It returns an error if a prior donation has more shares
+// than the lots that it matches.
+func subtractPriorDonations(input *Input) (prior PriorTotals, err error) {
+	if len(input.AlreadyDonated) == 0 {
+		return
+	}
+	lots := append([]LotJSON(nil), input.Lots...)
+	for _, donation := range input.AlreadyDonated {
+		if !donation.Shares.IsPositive() {
+			err = fmt.Errorf(`prior donation of %s acquired on %s must have a positive number of shares: %s`, donation.AssetName, donation.Date, donation.Shares)
+			return
+		}
+		remaining := donation.Shares
+		for m := range lots {
+			lot := &lots[m]
+			if !remaining.IsPositive() {
+				break
+			}
+			if !donation.matches(lot) || !lot.Shares.IsPositive() {
+				continue
+			}
+			shares := decimal.Min(remaining, lot.Shares)
+			lot.Shares = lot.Shares.Sub(shares)
+			if lot.MaxDonatableShares != nil {
+				maxShares := decimal.Max(lot.MaxDonatableShares.Sub(shares), decimal.Zero)
+				lot.MaxDonatableShares = &maxShares
+			}
+			prior.TotalValue = prior.TotalValue.Add(input.SharePrice(lot).Mul(shares))
+			prior.TotalCapitalGains = prior.TotalCapitalGains.Add(input.UnitCapitalGains(lot).Mul(shares))
+			remaining = remaining.Sub(shares)
+		}
+		if remaining.IsPositive() {
+			err = fmt.Errorf(`prior donation of %s shares of %s acquired on %s exceeds the lots' shares by %s`, donation.Shares, donation.AssetName, donation.Date, remaining)
+			return
+		}
+	}
+	input.Lots = lots[:0]
+	for _, lot := range lots {
+		if lot.Shares.IsPositive() {
+			input.Lots = append(input.Lots, lot)
+		}
+	}
+	input.AlreadyDonated = nil
+	return
+}
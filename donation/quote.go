@@ -0,0 +1,146 @@
+package donation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+// Quoting chooses which decimal values Marshal encodes as JSON strings
+// (which JSON parsers that read numbers as floats cannot round)
+// instead of numbers.
+type Quoting struct {
+	// Prices quotes the lots' shares, shareCosts, sharePrices,
+	// maxDonatableShares, and originalShares, the assetSummary's shares,
+	// and the assetSharePrices.
+	Prices bool
+
+	// Totals quotes the other decimal values, like totalValue,
+	// totalCapitalGains, the lots' capitalGains, the assetSummary's totals,
+	// and the config's amounts.
+	Totals bool
+}
+
+// lotArrays are the names of the fields that hold lots.
+var lotArrays = map[string]bool{"donation": true, "excludedLots": true, "remainingLots": true}
+
+// lotDecimals are the names of the decimal fields of lots.
+var lotDecimals = map[string]bool{"shares": true, "shareCost": true, "sharePrice": true, "maxDonatableShares": true, "originalShares": true}
+
+// totalDecimals are the names of the decimal fields
+// other than those of lots, assetSharePrices, and assetSummary.
+var totalDecimals = map[string]bool{
+	"donationAmount": true, "totalValue": true, "totalCapitalGains": true,
+	"longTermGains": true, "shortTermGains": true, "remainingBudget": true,
+	"budget": true, "gainsRatio": true, "lossCap": true, "excessLoss": true,
+	"estimatedTaxSavings": true, "step": true, "gainsPerDollar": true,
+	"feePercent": true, "ltcgRate": true, "stateRate": true, "incomeRate": true,
+	"minLotGain": true, "perLotFee": true, "ageWeight": true, "tolerance": true, "fxRate": true}
+
+// Marshal encodes output (an *Output, *Comparison, or []SweepPoint) as JSON
+// with the decimal values that quoting chooses as strings
+// and the others as numbers.
+// It recognizes decimal values by the names of their fields,
+// so it must not be used while decimal.MarshalJSONWithoutQuotes is set.
+func (quoting Quoting) Marshal(output interface{}) ([]byte, error) {
+	data, err := json.Marshal(output)
+	if err != nil || (quoting.Prices && quoting.Totals) {
+		return data, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var buffer bytes.Buffer
+	if err = quoting.rewrite(&buffer, decoder, nil); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// rewrite copies the next JSON value from decoder to buffer,
+// unquoting the decimal values that quoting does not quote.
+// path holds the names of the fields that contain the value.
+func (quoting Quoting) rewrite(buffer *bytes.Buffer, decoder *json.Decoder, path []string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '{' {
+			buffer.WriteByte('{')
+			for first := true; decoder.More(); first = false {
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				name, ok := key.(string)
+				if !ok {
+					return fmt.Errorf(`invalid object key: %v`, key)
+				}
+				if !first {
+					buffer.WriteByte(',')
+				}
+				encoded, _ := json.Marshal(name)
+				buffer.Write(encoded)
+				buffer.WriteByte(':')
+				if err = quoting.rewrite(buffer, decoder, append(path[:len(path):len(path)], name)); err != nil {
+					return err
+				}
+			}
+			buffer.WriteByte('}')
+		} else {
+			buffer.WriteByte('[')
+			for first := true; decoder.More(); first = false {
+				if !first {
+					buffer.WriteByte(',')
+				}
+				if err = quoting.rewrite(buffer, decoder, path); err != nil {
+					return err
+				}
+			}
+			buffer.WriteByte(']')
+		}
+		// Consume the closing delimiter.
+		_, err = decoder.Token()
+		return err
+	case string:
+		if isDecimal, isPrice := decimalField(path); isDecimal && (isPrice && !quoting.Prices || !isPrice && !quoting.Totals) {
+			if _, err := decimal.NewFromString(token); err == nil && json.Valid([]byte(token)) {
+				buffer.WriteString(token)
+				return nil
+			}
+		}
+	}
+	encoded, err := json.Marshal(token)
+	buffer.Write(encoded)
+	return err
+}
+
+// decimalField reports whether the field at path (see rewrite) is a decimal
+// and, if so, whether Quoting.Prices (instead of Quoting.Totals) covers it.
+func decimalField(path []string) (isDecimal bool, isPrice bool) {
+	n := len(path)
+	if n == 0 {
+		return
+	}
+	name, parent := path[n-1], ""
+	if n >= 2 {
+		parent = path[n-2]
+	}
+	switch {
+	case n >= 3 && path[n-3] == "assetSummary":
+		// Share counts follow Quoting.Prices like the lots' shares.
+		return true, name == "shares"
+	case parent == "assetSharePrices":
+		return true, true
+	case lotArrays[parent] && name == "capitalGains":
+		return true, false
+	case lotArrays[parent]:
+		return lotDecimals[name], true
+	case n == 1 && name == "donation":
+		// the donation amount of a SweepPoint
+		return true, false
+	}
+	return totalDecimals[name], false
+}
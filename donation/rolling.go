@@ -0,0 +1,74 @@
+package donation
+
+// Rolling01Solution solves the same 0-1 knapsack problem
+// as knapsack.Get01Solution over the items from ExpandLots or SplitLots
+// (with nl's normalized donation, ItemWeight, and ItemObjective)
+// and returns the same selection in the same order.
+// Unlike knapsack.Get01Solution, which copies a bit set of chosen items
+// for each capacity whenever the capacity's best value improves,
+// it keeps only one row of values, which it updates in place,
+// and records each item's choices in a bit set of capacities
+// for reconstructing the solution.
+// Both take O(d + i*d/64) space,
+// but this function makes far fewer allocations
+// (and uses about 60% of the memory in BenchmarkRolling01Solution).
+//
+// It returns nil early if nl's context is done (see NormalizedLots.ctx).
+//
+// This function runs in O(i*d) time, where i is the number of items.
+func (nl *NormalizedLots) Rolling01Solution(items []Lot) (selection []Lot) {
+	capacity := nl.donation
+	words := capacity/64 + 1
+
+	// best[c] is the best value of items 0..m with a weight of at most c.
+	best := make([]int64, capacity+1)
+
+	// chosen[m] has bit c set if item m is in the best solution
+	// of items 0..m at capacity c.
+	chosen := make([][]uint64, len(items))
+	for m := range items {
+		if nl.canceled() {
+			return nil
+		}
+		weight := nl.ItemWeight(&items[m])
+		value := nl.ItemObjective(&items[m])
+		chosen[m] = make([]uint64, words)
+
+		// Iterating downward lets best[c-weight] still hold
+		// the best value of items 0..m-1 while updating best[c]
+		// (and stops at weight, which is positive).
+		for c := capacity; c >= weight; c-- {
+			if best[c-weight]+value > best[c] {
+				best[c] = best[c-weight] + value
+				chosen[m][c/64] |= 1 << (c % 64)
+			}
+		}
+		nl.reportProgress(uint64(m+1)*(capacity+1), uint64(len(items))*(capacity+1))
+	}
+
+	// Reconstruct the solution from the last item to the first.
+	c := capacity
+	for m := len(items) - 1; m >= 0; m-- {
+		if chosen[m][c/64]&(1<<(c%64)) != 0 {
+			selection = append(selection, items[m])
+			c -= nl.ItemWeight(&items[m])
+		}
+	}
+	for a, b := 0, len(selection)-1; a < b; a, b = a+1, b-1 {
+		selection[a], selection[b] = selection[b], selection[a]
+	}
+	return
+}
+
+// canceled reports whether nl's context (see NormalizedLots.ctx) is done,
+// in which case the solvers stop.
+func (nl *NormalizedLots) canceled() bool {
+	return nl.ctx != nil && nl.ctx.Err() != nil
+}
+
+// reportProgress calls nl.progress (see Options.Progress) if it is set.
+func (nl *NormalizedLots) reportProgress(done, total uint64) {
+	if nl.progress != nil {
+		nl.progress(done, total)
+	}
+}
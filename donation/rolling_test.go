@@ -0,0 +1,39 @@
+package donation
+
+import (
+	"github.com/johnmuirjr/go-knapsack"
+	"reflect"
+	"testing"
+)
+
+// rollingGenerate generates a problem with hundreds of items
+// and a capacity of 50000 for a donation of rollingDonation.
+var rollingGenerate = GenerateOptions{Seed: 1, Assets: 8, LotsPerAsset: 10, MaxShares: 100, PriceDecimals: 1}
+
+const rollingDonation = "5000"
+
+func TestRolling01Solution(t *testing.T) {
+	for _, donation := range []string{"100", "1000", rollingDonation} {
+		nl, items := benchmarkLots(t, rollingGenerate, donation)
+		want := knapsack.Get01Solution(nl.donation, items, nl.ItemWeight, nl.ItemObjective)
+		if selection := nl.Rolling01Solution(items); !reflect.DeepEqual(selection, want) {
+			t.Errorf("donation %s: selection of %d items differs from knapsack.Get01Solution's %d", donation, len(selection), len(want))
+		}
+	}
+}
+
+func BenchmarkRolling01Solution(b *testing.B) {
+	nl, items := benchmarkLots(b, rollingGenerate, rollingDonation)
+	b.Run("knapsack", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			knapsack.Get01Solution(nl.donation, items, nl.ItemWeight, nl.ItemObjective)
+		}
+	})
+	b.Run("rolling", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			nl.Rolling01Solution(items)
+		}
+	})
+}
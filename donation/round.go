@@ -0,0 +1,141 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+// Rounding modes for Output.Round
+const (
+	// Round halves away from zero (so 2.345 becomes 2.35 and -2.345 becomes -2.35).
+	RoundHalfUp = "half-up"
+
+	// Round halves to the nearest even digit (banker's rounding),
+	// so 2.345 becomes 2.34 and 2.355 becomes 2.36.
+	RoundHalfEven = "half-even"
+)
+
+// Round rounds the monetary totals of output (donationAmount,
+// totalValue, totalCapitalGains, longTermGains, shortTermGains,
+// remainingBudget, budget,
+// the lots' capitalGains, the asset summaries' totals, the eligible totals,
+// the alternatives' and charities' totals,
+// lossCap, excessLoss, estimatedTaxSavings, the combined totals,
+// and the marginal totalCapitalGains) to the specified number of decimal places
+// using mode (RoundHalfUp or RoundHalfEven, or RoundHalfUp if empty).
+// If prices is set, it also rounds assetSharePrices.
+// Round only changes how output is presented,
+// so call it after Optimize.
+func (output *Output) Round(places int32, mode string, prices bool) error {
+	round, err := rounder(places, mode)
+	if err != nil {
+		return err
+	}
+	output.mapTotals(round)
+	if prices {
+		// Copy the prices because they may belong to the caller's Input.
+		roundedPrices := make(map[string]decimal.Decimal, len(output.AssetSharePrices))
+		for name, price := range output.AssetSharePrices {
+			roundedPrices[name] = round(price)
+		}
+		output.AssetSharePrices = roundedPrices
+	}
+	return nil
+}
+
+// mapTotals replaces each of the monetary totals of output
+// that Round rounds (other than assetSharePrices) with round's result.
+func (output *Output) mapTotals(round func(decimal.Decimal) decimal.Decimal) {
+	output.DonationAmount = round(output.DonationAmount)
+	output.TotalValue = round(output.TotalValue)
+	output.TotalCapitalGains = round(output.TotalCapitalGains)
+	output.RemainingBudget = round(output.RemainingBudget)
+	if output.LongTermGains != nil {
+		// Derive the short-term gains so that the rounded parts
+		// still add up to the rounded total.
+		longTerm := round(*output.LongTermGains)
+		shortTerm := output.TotalCapitalGains.Sub(longTerm)
+		output.LongTermGains, output.ShortTermGains = &longTerm, &shortTerm
+	}
+	if output.Budget != nil {
+		budget := round(*output.Budget)
+		output.Budget = &budget
+	}
+	roundSummaries := func(summaries map[string]AssetSummary) {
+		for name, summary := range summaries {
+			summary.TotalValue = round(summary.TotalValue)
+			summary.TotalCapitalGains = round(summary.TotalCapitalGains)
+			summaries[name] = summary
+		}
+	}
+	roundLots := func(lots []OutputLot) {
+		for m := range lots {
+			lots[m].CapitalGains = round(lots[m].CapitalGains)
+		}
+	}
+	roundSummaries(output.AssetSummary)
+	roundLots(output.Lots)
+	for m := range output.Alternatives {
+		alternative := &output.Alternatives[m]
+		roundLots(alternative.Lots)
+		alternative.TotalValue = round(alternative.TotalValue)
+		alternative.TotalCapitalGains = round(alternative.TotalCapitalGains)
+		alternative.RemainingBudget = round(alternative.RemainingBudget)
+		roundSummaries(alternative.AssetSummary)
+	}
+	for m := range output.Charities {
+		charity := &output.Charities[m]
+		roundLots(charity.Lots)
+		charity.DonationAmount = round(charity.DonationAmount)
+		charity.TotalValue = round(charity.TotalValue)
+		charity.TotalCapitalGains = round(charity.TotalCapitalGains)
+		charity.RemainingBudget = round(charity.RemainingBudget)
+		if charity.Budget != nil {
+			budget := round(*charity.Budget)
+			charity.Budget = &budget
+		}
+		roundSummaries(charity.AssetSummary)
+	}
+	if output.Eligible != nil {
+		eligible := *output.Eligible
+		eligible.TotalValue = round(eligible.TotalValue)
+		eligible.TotalCapitalGains = round(eligible.TotalCapitalGains)
+		output.Eligible = &eligible
+	}
+	if output.LossCap != nil {
+		lossCap := round(*output.LossCap)
+		output.LossCap = &lossCap
+	}
+	if output.ExcessLoss != nil {
+		excessLoss := round(*output.ExcessLoss)
+		output.ExcessLoss = &excessLoss
+	}
+	if output.EstimatedTaxSavings != nil {
+		savings := round(*output.EstimatedTaxSavings)
+		output.EstimatedTaxSavings = &savings
+	}
+	if output.Combined != nil {
+		combined := *output.Combined
+		combined.TotalValue = round(combined.TotalValue)
+		combined.TotalCapitalGains = round(combined.TotalCapitalGains)
+		output.Combined = &combined
+	}
+	if output.Marginal != nil {
+		marginal := *output.Marginal
+		marginal.TotalCapitalGains = round(marginal.TotalCapitalGains)
+		output.Marginal = &marginal
+	}
+}
+
+// rounder returns a function that rounds decimals
+// to the specified number of decimal places using mode
+// (see Output.Round).
+func rounder(places int32, mode string) (func(decimal.Decimal) decimal.Decimal, error) {
+	switch mode {
+	case "", RoundHalfUp:
+		return func(d decimal.Decimal) decimal.Decimal { return d.Round(places) }, nil
+	case RoundHalfEven:
+		return func(d decimal.Decimal) decimal.Decimal { return d.RoundBank(places) }, nil
+	}
+	return nil, fmt.Errorf(`unknown rounding mode: %s`, mode)
+}
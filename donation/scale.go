@@ -0,0 +1,51 @@
+package donation
+
+import (
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// applyScale rounds the share prices, costs, and cash amounts in input
+// and the donation amount in opts to opts.ScaleDecimals decimal places
+// (if it is not nil), which makes them the precision of NormalizedLots.
+// Cash amounts and the donation amount are rounded down
+// so that the donation never exceeds either.
+func applyScale(input *Input, opts *Options) {
+	if opts.ScaleDecimals == nil {
+		return
+	}
+	places := *opts.ScaleDecimals
+	scaled := *input
+	scaled.AssetSharePrices = make(map[string]decimal.Decimal, len(input.AssetSharePrices))
+	for name, price := range input.AssetSharePrices {
+		scaled.AssetSharePrices[name] = price.Round(places)
+	}
+	scaled.Lots = make([]LotJSON, len(input.Lots))
+	for m, lot := range input.Lots {
+		if lot.Cash {
+			lot.Shares = lot.Shares.Truncate(places)
+			if lot.MaxDonatableShares != nil {
+				maxShares := lot.MaxDonatableShares.Truncate(places)
+				lot.MaxDonatableShares = &maxShares
+			}
+		} else {
+			lot.ShareCost = lot.ShareCost.Round(places)
+			if lot.SharePrice != nil {
+				price := lot.SharePrice.Round(places)
+				lot.SharePrice = &price
+			}
+		}
+		scaled.Lots[m] = lot
+	}
+	scaled.Charities = make([]Charity, len(input.Charities))
+	for m, charity := range input.Charities {
+		charity.Budget = charity.Budget.Truncate(places)
+		scaled.Charities[m] = charity
+	}
+	*input = scaled
+	if opts.Donation != "" && !strings.HasSuffix(opts.Donation, "%") {
+		if amount, err := decimal.NewFromString(opts.Donation); err == nil {
+			opts.Donation = amount.Truncate(places).String()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package donation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Separators are the decimal and thousands separators
+// of decimals in an input (like "," and "." for "1.000,50").
+// Empty separators mean "." and no thousands separator,
+// which is what the input must use without Separators.
+type Separators struct {
+	Decimal   string
+	Thousands string
+}
+
+// Check returns an error if s's separators are ambiguous.
+func (s Separators) Check() error {
+	decimal := s.Decimal
+	if decimal == "" {
+		decimal = "."
+	}
+	if s.Thousands == decimal {
+		return fmt.Errorf(`decimal and thousands separators must differ: %q`, decimal)
+	}
+	return nil
+}
+
+// Normalize rewrites number (a decimal or percentage
+// with s's separators) with "." as the decimal separator
+// and without thousands separators.
+func (s Separators) Normalize(number string) string {
+	if s.Thousands != "" {
+		number = strings.ReplaceAll(number, s.Thousands, "")
+	}
+	if s.Decimal != "" && s.Decimal != "." {
+		number = strings.ReplaceAll(number, s.Decimal, ".")
+	}
+	return number
+}
+
+// NormalizeJSON rewrites the numeric strings of share prices, shares,
+// costs, budgets, and the donation amount in data (an input JSON object)
+// with Normalize.  JSON numbers always use ".", so it leaves them alone.
+func (s Separators) NormalizeJSON(data []byte) ([]byte, error) {
+	if s.Decimal == "" && s.Thousands == "" {
+		return data, nil
+	}
+	var input map[string]json.RawMessage
+	if err := unmarshalObject(data, &input); err != nil {
+		return nil, fmt.Errorf(`input: %w`, err)
+	}
+	s.normalizeField(input, "donation")
+	if prices, ok := input["assetSharePrices"]; ok {
+		var priceMap map[string]json.RawMessage
+		if unmarshalObject(prices, &priceMap) == nil {
+			for name := range priceMap {
+				s.normalizeField(priceMap, name)
+			}
+			input["assetSharePrices"], _ = json.Marshal(priceMap)
+		}
+	}
+	normalizeArray := func(name string, fields ...string) {
+		var objects []map[string]json.RawMessage
+		if raw, ok := input[name]; ok && json.Unmarshal(raw, &objects) == nil {
+			for _, object := range objects {
+				for _, field := range fields {
+					s.normalizeField(object, field)
+				}
+			}
+			input[name], _ = json.Marshal(objects)
+		}
+	}
+	normalizeArray("lots", "shares", "shareCost", "sharePrice", "maxDonatableShares")
+	normalizeArray("charities", "budget")
+	normalizeArray("alreadyDonated", "shares", "shareCost")
+	return json.Marshal(input)
+}
+
+// normalizeField normalizes object[name] if it is a JSON string.
+func (s Separators) normalizeField(object map[string]json.RawMessage, name string) {
+	value, ok := object[name]
+	if !ok || !bytes.HasPrefix(bytes.TrimSpace(value), []byte(`"`)) {
+		return
+	}
+	var number string
+	if json.Unmarshal(value, &number) == nil {
+		object[name], _ = json.Marshal(s.Normalize(number))
+	}
+}
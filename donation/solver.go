@@ -0,0 +1,205 @@
+package donation
+
+// lotSolution is the best donation found for a knapsack capacity.
+type lotSolution struct {
+	// total knapsack value of the donation
+	value int64
+
+	// number of distinct lots in the donation
+	lots int
+
+	// whether no donation has exactly this capacity's weight
+	// (only used when solving for exact weights)
+	unreachable bool
+}
+
+// betterThan reports whether s is reachable and has a greater value than t
+// or the same value and fewer lots (or t is unreachable).
+func (s lotSolution) betterThan(t lotSolution) bool {
+	if s.unreachable || t.unreachable {
+		return !s.unreachable
+	}
+	return s.value > t.value || (s.value == t.value && s.lots < t.lots)
+}
+
+// lotSolver is the dynamic programming table of a bounded knapsack problem
+// in which each lot contributes up to all of its share units
+// (or, with Options.WholeLots, none or all of them)
+// and costs Options.PerLotFee if it contributes any.
+// If NormalizedLots.maxLots is positive, the table has a layer
+// for each number of lots up to it, so no solution has more lots.
+type lotSolver struct {
+	nl *NormalizedLots
+
+	// best[j][c] is the best solution with a weight of at most c
+	// (or exactly c when solving for exact weights)
+	// and at most j lots (or any number of lots in the only layer
+	// if nl.maxLots is not positive).
+	best [][]lotSolution
+
+	// choices[m][j][c] is the number of share units of lot m
+	// in the best solution of lots 0..m in best[j][c].
+	choices [][][]uint64
+}
+
+// lotLayers returns the number of layers of nl's lotSolver.
+func (nl *NormalizedLots) lotLayers() uint64 {
+	if nl.maxLots > 0 {
+		return uint64(nl.maxLots) + 1
+	}
+	return 1
+}
+
+// solveByLot fills a lotSolver for nl's lots and normalized donation.
+// If exactWeights is set, each capacity's solution must weigh exactly
+// that capacity.
+// It returns nil early if nl's context is done (see NormalizedLots.ctx).
+//
+// This function runs in O(s*d*k) time and uses O(l*d*k) space,
+// where s is the number of share units, l is the number of lots,
+// d is the normalized donation, and k is nl.lotLayers().
+func (nl *NormalizedLots) solveByLot(exactWeights bool) *lotSolver {
+	capacity := nl.donation
+	layers := int(nl.lotLayers())
+	solver := &lotSolver{nl: nl, best: make([][]lotSolution, layers), choices: make([][][]uint64, len(nl.lots))}
+	for j := range solver.best {
+		solver.best[j] = make([]lotSolution, capacity+1)
+		if exactWeights {
+			for c := range solver.best[j][1:] {
+				solver.best[j][c+1].unreachable = true
+			}
+		}
+	}
+	shareUnits, done := nl.GetShareUnits(), uint64(0)
+	for m := range nl.lots {
+		if nl.canceled() {
+			return nil
+		}
+		lot := &nl.lots[m]
+		weight := lot.price
+		value := nl.objective(nl.weighByAge(lot, nl.score(lot)), weight)
+		fee := nl.lotFee
+		if nl.ageWeighted {
+			fee *= ageScale
+		}
+		fee = nl.objective(fee, 0)
+		solver.choices[m] = make([][]uint64, layers)
+		minShares := uint64(1)
+		if nl.wholeLots {
+			minShares = lot.shares
+		}
+
+		// Iterating downward over both layers and capacities
+		// lets best[j-1][c-k*weight] (or, without layers, best[0][c-k*weight])
+		// still hold the best solution of lots 0..m-1 while updating best[j][c].
+		// The layer of no lots never changes.
+		for j := layers - 1; j >= 0; j-- {
+			if nl.canceled() {
+				return nil
+			}
+			previousLayer := j - 1
+			if layers == 1 {
+				previousLayer = 0
+			} else if j == 0 {
+				break
+			}
+			solver.choices[m][j] = make([]uint64, capacity+1)
+			for c := capacity + 1; c > 0; {
+				c--
+				bestHere := solver.best[j][c]
+				for k := minShares; k <= lot.shares && k*weight <= c; k++ {
+					previous := solver.best[previousLayer][c-k*weight]
+					if previous.unreachable {
+						continue
+					}
+					candidate := lotSolution{value: previous.value + int64(k)*value - fee, lots: previous.lots + 1}
+					if candidate.betterThan(bestHere) {
+						bestHere = candidate
+						solver.choices[m][j][c] = k
+					}
+				}
+				solver.best[j][c] = bestHere
+			}
+		}
+		done += lot.shares
+		nl.reportProgress(done*(capacity+1), shareUnits*(capacity+1))
+	}
+	return solver
+}
+
+// top returns the best solutions of solver's last layer
+// (those with the most lots allowed).
+func (solver *lotSolver) top() []lotSolution {
+	return solver.best[len(solver.best)-1]
+}
+
+// reconstruct returns the lots in the best solution at capacity c
+// (of the last layer) with their shares set
+// to the numbers of share units to donate.
+func (solver *lotSolver) reconstruct(c uint64) (selection []Lot) {
+	j := len(solver.best) - 1
+	for m := len(solver.nl.lots) - 1; m >= 0; m-- {
+		if len(solver.best) > 1 && j == 0 {
+			// The layer of no lots has no choices.
+			break
+		}
+		if k := solver.choices[m][j][c]; k > 0 {
+			lot := solver.nl.lots[m]
+			lot.shares = k
+			selection = append(selection, lot)
+			c -= k * lot.price
+			if len(solver.best) > 1 {
+				j--
+			}
+		}
+	}
+	for a, b := 0, len(selection)-1; a < b; a, b = a+1, b-1 {
+		selection[a], selection[b] = selection[b], selection[a]
+	}
+	return
+}
+
+// MinimizeLotsSolution solves the bounded knapsack problem
+// in which each lot in nl contributes up to all of its share units
+// and returns the lots in the solution with their shares set
+// to the numbers of share units to donate.
+// Among solutions with the same total Value
+// (and total price when maximizing efficiency),
+// it chooses one with the fewest distinct lots.
+// It returns nil if nl's context is done (see NormalizedLots.ctx).
+func (nl *NormalizedLots) MinimizeLotsSolution() []Lot {
+	solver := nl.solveByLot(false)
+	if solver == nil {
+		return nil
+	}
+	return solver.reconstruct(nl.donation)
+}
+
+// ExactSolution is like MinimizeLotsSolution but chooses
+// the solution with the greatest total Value among those
+// whose total normalized price is within tolerance
+// of the greatest total normalized price that does not exceed
+// the normalized donation.
+func (nl *NormalizedLots) ExactSolution(tolerance uint64) []Lot {
+	solver := nl.solveByLot(true)
+	if solver == nil {
+		return nil
+	}
+	best := solver.top()
+	closest := nl.donation
+	for best[closest].unreachable {
+		closest--
+	}
+	lowest := uint64(0)
+	if tolerance < closest {
+		lowest = closest - tolerance
+	}
+	chosen := closest
+	for c := closest; c > lowest; {
+		c--
+		if best[c].betterThan(best[chosen]) {
+			chosen = c
+		}
+	}
+	return solver.reconstruct(chosen)
+}
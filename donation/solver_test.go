@@ -0,0 +1,34 @@
+package donation
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestPerLotFee(t *testing.T) {
+	// Six shares of A then four of B capture the most gains,
+	// but all ten shares of B capture nearly as much with one lot,
+	// and no lot is worth a fee greater than its gains.
+	input := testInput([]LotJSON{testLot("A", "6", "5"), testLot("B", "10", "5.1")}, "A", "10", "B", "10")
+	tests := []struct {
+		fee       string
+		wantLots  int
+		wantGains string
+	}{
+		{"0", 2, "49.6"},
+		{"0.3", 2, "49.6"},
+		{"1", 1, "49"},
+		{"100", 0, "0"},
+	}
+	for _, test := range tests {
+		opts := testOptions("100")
+		opts.PerLotFee = decimal.RequireFromString(test.fee)
+		output, err := Optimize(input, opts)
+		if err != nil {
+			t.Fatalf("fee %s: %v", test.fee, err)
+		}
+		if len(output.Lots) != test.wantLots || output.TotalCapitalGains.String() != test.wantGains {
+			t.Errorf("fee %s: donated %d lots with gains %s, want %d with gains %s", test.fee, len(output.Lots), output.TotalCapitalGains, test.wantLots, test.wantGains)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package donation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+)
+
+// MaxSweepPoints is the maximum number of donation amounts
+// that SweepAmounts returns.
+const MaxSweepPoints = 10000
+
+// SweepPoint is the result of optimizing one of several donation amounts.
+type SweepPoint struct {
+	DonationAmount    decimal.Decimal `json:"donation"`
+	TotalValue        decimal.Decimal `json:"totalValue"`
+	TotalCapitalGains decimal.Decimal `json:"totalCapitalGains"`
+	RemainingBudget   decimal.Decimal `json:"remainingBudget"`
+}
+
+// NewSweepPoint returns the SweepPoint of output.
+func NewSweepPoint(output *Output) SweepPoint {
+	return SweepPoint{
+		DonationAmount:    output.DonationAmount,
+		TotalValue:        output.TotalValue,
+		TotalCapitalGains: output.TotalCapitalGains,
+		RemainingBudget:   output.RemainingBudget}
+}
+
+// SweepAmounts returns the donation amounts from start to end
+// (including end if the steps reach it) in increments of step.
+func SweepAmounts(start, end, step decimal.Decimal) (amounts []decimal.Decimal, err error) {
+	if !start.IsPositive() {
+		err = fmt.Errorf(`sweep start must be positive: %s`, start)
+		return
+	}
+	if end.LessThan(start) {
+		err = fmt.Errorf(`sweep end must not be less than its start: %s`, end)
+		return
+	}
+	if !step.IsPositive() {
+		err = fmt.Errorf(`sweep step must be positive: %s`, step)
+		return
+	}
+	if end.Sub(start).Div(step).GreaterThanOrEqual(decimal.NewFromInt(MaxSweepPoints)) {
+		err = fmt.Errorf(`sweep has more than %d donation amounts`, MaxSweepPoints)
+		return
+	}
+	for amount := start; !amount.GreaterThan(end); amount = amount.Add(step) {
+		amounts = append(amounts, amount)
+	}
+	return
+}
+
+// WriteSweepCSV writes points as CSV records.
+func WriteSweepCSV(w io.Writer, points []SweepPoint) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"donation", "totalValue", "totalCapitalGains", "remainingBudget"})
+	for _, point := range points {
+		writer.Write([]string{
+			point.DonationAmount.String(),
+			point.TotalValue.String(),
+			point.TotalCapitalGains.String(),
+			point.RemainingBudget.String()})
+	}
+	writer.Flush()
+	return writer.Error()
+}
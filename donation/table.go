@@ -0,0 +1,38 @@
+package donation
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"text/tabwriter"
+)
+
+// WriteTable writes the donation lots in output as an aligned table
+// for people to read, followed by a row containing the totals.
+// If places is not negative, it rounds the monetary columns
+// (share price, unit gain, and value) to that many decimal places
+// using mode (see Output.Round).
+func WriteTable(w io.Writer, output *Output, places int32, mode string) error {
+	round := func(d decimal.Decimal) decimal.Decimal { return d }
+	if places >= 0 {
+		var err error
+		if round, err = rounder(places, mode); err != nil {
+			return err
+		}
+	}
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "ASSET\tDATE\tSHARES\tSHARE PRICE\tUNIT GAIN\tVALUE\tCAPITAL GAINS\t")
+	for _, lot := range output.Lots {
+		price, cost := output.lotPriceAndCost(&lot.LotJSON)
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			lot.AssetName,
+			lot.Date,
+			lot.Shares,
+			round(price),
+			round(price.Sub(cost)),
+			round(price.Mul(lot.Shares)),
+			round(price.Sub(cost).Mul(lot.Shares)))
+	}
+	fmt.Fprintf(writer, "TOTAL\t\t\t\t\t%s\t%s\t\n", round(output.TotalValue), round(output.TotalCapitalGains))
+	return writer.Flush()
+}
@@ -0,0 +1,35 @@
+package donation
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// estimateTaxSavings sets output.EstimatedTaxSavings from its totals
+// and the tax rates in opts (if the relevant rate is set).
+//
+// The estimate assumes that donating capital gains avoids tax on all of them
+// at opts.LTCGRate plus opts.StateRate and that the donation's capital losses,
+// up to opts.LossCap (if it is set), offset ordinary income
+// taxed at opts.IncomeRate.
+// It ignores other gains and losses, carryovers, phase-outs,
+// state taxes on income, and the value of the charitable deduction itself.
+func estimateTaxSavings(output *Output, opts *Options) {
+	var savings decimal.Decimal
+	if opts.MaximizeLosses {
+		if opts.IncomeRate.IsZero() {
+			return
+		}
+		loss := decimal.Max(output.TotalCapitalGains.Neg(), decimal.Zero)
+		if opts.LossCap.IsPositive() {
+			loss = decimal.Min(loss, opts.LossCap)
+		}
+		savings = loss.Mul(opts.IncomeRate)
+	} else {
+		rate := opts.LTCGRate.Add(opts.StateRate)
+		if rate.IsZero() {
+			return
+		}
+		savings = decimal.Max(output.TotalCapitalGains, decimal.Zero).Mul(rate)
+	}
+	output.EstimatedTaxSavings = &savings
+}
@@ -0,0 +1,60 @@
+package donation
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestEstimateTaxSavings(t *testing.T) {
+	tests := []struct {
+		name                            string
+		gains                           string
+		ltcgRate, stateRate, incomeRate string
+		lossCap                         string
+		maximizeLosses                  bool
+		want                            string
+	}{
+		{"federal", "1000", "0.15", "0", "0", "0", false, "150"},
+		{"federal and state", "1000", "0.15", "0.05", "0", "0", false, "200"},
+		{"state only", "1000", "0", "0.0725", "0", "0", false, "72.5"},
+		{"no rates", "1000", "0", "0", "0.24", "0", false, ""},
+		{"no gains", "-50", "0.2", "0.1", "0", "0", false, "0"},
+		{"losses", "-1000", "0.15", "0.05", "0.24", "0", true, "240"},
+		{"capped losses", "-5000", "0.15", "0.05", "0.24", "3000", true, "720"},
+		{"losses without an income rate", "-1000", "0.15", "0.05", "0", "0", true, ""},
+	}
+	for _, test := range tests {
+		output := Output{TotalCapitalGains: decimal.RequireFromString(test.gains)}
+		opts := Options{
+			MaximizeLosses: test.maximizeLosses,
+			LTCGRate:       decimal.RequireFromString(test.ltcgRate),
+			StateRate:      decimal.RequireFromString(test.stateRate),
+			IncomeRate:     decimal.RequireFromString(test.incomeRate),
+			LossCap:        decimal.RequireFromString(test.lossCap)}
+		estimateTaxSavings(&output, &opts)
+		got := ""
+		if output.EstimatedTaxSavings != nil {
+			got = output.EstimatedTaxSavings.String()
+		}
+		if got != test.want {
+			t.Errorf("%s: estimated tax savings %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCombinedTaxRates(t *testing.T) {
+	input := testInput([]LotJSON{testLot("A", "10", "40")}, "A", "100")
+	opts := testOptions("1000")
+	opts.LTCGRate, opts.StateRate = decimal.RequireFromString("0.15"), decimal.RequireFromString("0.0495")
+	output, err := Optimize(input, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "119.7"; output.EstimatedTaxSavings == nil || output.EstimatedTaxSavings.String() != want {
+		t.Errorf("estimated tax savings %v, want %s", output.EstimatedTaxSavings, want)
+	}
+	opts.LTCGRate, opts.StateRate = decimal.RequireFromString("0.6"), decimal.RequireFromString("0.5")
+	if _, err = Optimize(input, opts); err == nil || err.Error() != "tax rates must be between 0 and 1: 1.1" {
+		t.Errorf("error %v for combined rates above 1", err)
+	}
+}
@@ -0,0 +1,224 @@
+package donation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// ValidateJSON checks that data is a JSON object with the structure of Input
+// and returns an error naming the location of the first problem it finds
+// (like lots[2].shareCost).
+func ValidateJSON(data []byte) error {
+	var input map[string]json.RawMessage
+	if err := unmarshalObject(data, &input); err != nil {
+		return fmt.Errorf(`input: %w`, err)
+	}
+	// assetSharePrices is optional so that an input without lots
+	// (like {}) is valid; lots without prices fail in NewNormalizedLots.
+	if prices, ok := input["assetSharePrices"]; ok {
+		var priceMap map[string]json.RawMessage
+		if err := unmarshalObject(prices, &priceMap); err != nil {
+			return fmt.Errorf(`assetSharePrices: %w`, err)
+		}
+		for name, price := range priceMap {
+			if err := validateDecimal(price); err != nil {
+				return fmt.Errorf(`assetSharePrices[%q]: %w`, name, err)
+			}
+		}
+	}
+	if lots, ok := input["lots"]; ok {
+		var lotList []json.RawMessage
+		if !bytes.HasPrefix(bytes.TrimSpace(lots), []byte("[")) || json.Unmarshal(lots, &lotList) != nil {
+			return fmt.Errorf(`lots: must be an array`)
+		}
+		for m, lot := range lotList {
+			if err := validateLot(lot); err != nil {
+				return fmt.Errorf(`lots[%d]%w`, m, err)
+			}
+		}
+	}
+	if charities, ok := input["charities"]; ok {
+		var charityList []json.RawMessage
+		if !bytes.HasPrefix(bytes.TrimSpace(charities), []byte("[")) || json.Unmarshal(charities, &charityList) != nil {
+			return fmt.Errorf(`charities: must be an array`)
+		}
+		for m, charity := range charityList {
+			if err := validateCharity(charity); err != nil {
+				return fmt.Errorf(`charities[%d]%w`, m, err)
+			}
+		}
+	}
+	if donation, ok := input["donation"]; ok {
+		var amount string
+		if json.Unmarshal(donation, &amount) != nil {
+			amount = string(bytes.TrimSpace(donation))
+		}
+		if _, err := decimal.NewFromString(strings.TrimSuffix(amount, "%")); err != nil {
+			return fmt.Errorf(`donation: must be a number, numeric string, or percentage: %s`, donation)
+		}
+	}
+	if aliases, ok := input["aliases"]; ok {
+		var aliasMap map[string]json.RawMessage
+		if err := unmarshalObject(aliases, &aliasMap); err != nil {
+			return fmt.Errorf(`aliases: %w`, err)
+		}
+		for alias, canonical := range aliasMap {
+			var name string
+			if json.Unmarshal(canonical, &name) != nil {
+				return fmt.Errorf(`aliases[%q]: must be a string`, alias)
+			}
+		}
+	}
+	if donations, ok := input["alreadyDonated"]; ok {
+		var donationList []json.RawMessage
+		if !bytes.HasPrefix(bytes.TrimSpace(donations), []byte("[")) || json.Unmarshal(donations, &donationList) != nil {
+			return fmt.Errorf(`alreadyDonated: must be an array`)
+		}
+		for m, donation := range donationList {
+			if err := validatePriorDonation(donation); err != nil {
+				return fmt.Errorf(`alreadyDonated[%d]%w`, m, err)
+			}
+		}
+	}
+	if purchases, ok := input["recentPurchases"]; ok {
+		var purchaseList []json.RawMessage
+		if !bytes.HasPrefix(bytes.TrimSpace(purchases), []byte("[")) || json.Unmarshal(purchases, &purchaseList) != nil {
+			return fmt.Errorf(`recentPurchases: must be an array`)
+		}
+		for m, purchase := range purchaseList {
+			if err := validatePurchase(purchase); err != nil {
+				return fmt.Errorf(`recentPurchases[%d]%w`, m, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateLot checks that data is a JSON object with the structure of LotJSON.
+// Its errors start with the problematic field (like .shares)
+// so that they follow the lot's location.
+func validateLot(data json.RawMessage) error {
+	var lot map[string]json.RawMessage
+	if err := unmarshalObject(data, &lot); err != nil {
+		return fmt.Errorf(`: %w`, err)
+	}
+	if err := validateStrings(lot, "assetName", "date"); err != nil {
+		return err
+	}
+	cash := false
+	if value, ok := lot["cash"]; ok && json.Unmarshal(value, &cash) != nil {
+		return fmt.Errorf(`.cash: must be a boolean`)
+	}
+	var pinned bool
+	if value, ok := lot["pinned"]; ok && json.Unmarshal(value, &pinned) != nil {
+		return fmt.Errorf(`.pinned: must be a boolean`)
+	}
+	for _, field := range []string{"shares", "shareCost", "maxDonatableShares", "sharePrice"} {
+		value, ok := lot[field]
+		if !ok {
+			if field == "maxDonatableShares" || field == "sharePrice" || (field == "shareCost" && cash) {
+				continue
+			}
+			return fmt.Errorf(`.%s: missing required field`, field)
+		}
+		if err := validateDecimal(value); err != nil {
+			return fmt.Errorf(`.%s: %w`, field, err)
+		}
+	}
+	return nil
+}
+
+// validatePurchase checks that data is a JSON object
+// with the structure of Purchase.
+// Like validateLot's errors, its errors start with the problematic field.
+func validatePurchase(data json.RawMessage) error {
+	var purchase map[string]json.RawMessage
+	if err := unmarshalObject(data, &purchase); err != nil {
+		return fmt.Errorf(`: %w`, err)
+	}
+	return validateStrings(purchase, "assetName", "date")
+}
+
+// validatePriorDonation checks that data is a JSON object
+// with the structure of PriorDonation.
+// Like validateLot's errors, its errors start with the problematic field.
+func validatePriorDonation(data json.RawMessage) error {
+	var donation map[string]json.RawMessage
+	if err := unmarshalObject(data, &donation); err != nil {
+		return fmt.Errorf(`: %w`, err)
+	}
+	if err := validateStrings(donation, "assetName", "date"); err != nil {
+		return err
+	}
+	shares, ok := donation["shares"]
+	if !ok {
+		return fmt.Errorf(`.shares: missing required field`)
+	}
+	if err := validateDecimal(shares); err != nil {
+		return fmt.Errorf(`.shares: %w`, err)
+	}
+	if cost, ok := donation["shareCost"]; ok {
+		if err := validateDecimal(cost); err != nil {
+			return fmt.Errorf(`.shareCost: %w`, err)
+		}
+	}
+	return nil
+}
+
+// validateCharity checks that data is a JSON object
+// with the structure of Charity.
+// Like validateLot's errors, its errors start with the problematic field.
+func validateCharity(data json.RawMessage) error {
+	var charity map[string]json.RawMessage
+	if err := unmarshalObject(data, &charity); err != nil {
+		return fmt.Errorf(`: %w`, err)
+	}
+	if err := validateStrings(charity, "name"); err != nil {
+		return err
+	}
+	budget, ok := charity["budget"]
+	if !ok {
+		return fmt.Errorf(`.budget: missing required field`)
+	}
+	if err := validateDecimal(budget); err != nil {
+		return fmt.Errorf(`.budget: %w`, err)
+	}
+	return nil
+}
+
+// validateStrings checks that object has each of fields
+// and that they are strings.
+func validateStrings(object map[string]json.RawMessage, fields ...string) error {
+	for _, field := range fields {
+		value, ok := object[field]
+		if !ok {
+			return fmt.Errorf(`.%s: missing required field`, field)
+		}
+		var s string
+		if json.Unmarshal(value, &s) != nil {
+			return fmt.Errorf(`.%s: must be a string`, field)
+		}
+	}
+	return nil
+}
+
+// unmarshalObject unmarshals data into object
+// if data is a JSON object.
+func unmarshalObject(data json.RawMessage, object *map[string]json.RawMessage) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) || json.Unmarshal(data, object) != nil {
+		return fmt.Errorf(`must be an object`)
+	}
+	return nil
+}
+
+// validateDecimal checks that data is a JSON number or numeric string.
+func validateDecimal(data json.RawMessage) error {
+	var d decimal.Decimal
+	if err := d.UnmarshalJSON(data); err != nil || bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return fmt.Errorf(`must be a number or numeric string: %s`, data)
+	}
+	return nil
+}
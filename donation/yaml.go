@@ -0,0 +1,118 @@
+package donation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+)
+
+// YAMLToJSON converts a YAML document into the equivalent JSON
+// so that it can be validated and decoded exactly like JSON input.
+// Numbers keep their literal digits (instead of becoming float64s)
+// and dates and other timestamps become strings.
+func YAMLToJSON(data []byte) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	if len(document.Content) == 0 {
+		buffer.WriteString("null")
+	} else if err := writeYAMLNodeAsJSON(&buffer, document.Content[0]); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeYAMLNodeAsJSON writes node to buffer as JSON.
+func writeYAMLNodeAsJSON(buffer *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return writeYAMLNodeAsJSON(buffer, node.Alias)
+	case yaml.MappingNode:
+		buffer.WriteByte('{')
+		for m := 0; m+1 < len(node.Content); m += 2 {
+			if m > 0 {
+				buffer.WriteByte(',')
+			}
+			key, _ := json.Marshal(node.Content[m].Value)
+			buffer.Write(key)
+			buffer.WriteByte(':')
+			if err := writeYAMLNodeAsJSON(buffer, node.Content[m+1]); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('}')
+	case yaml.SequenceNode:
+		buffer.WriteByte('[')
+		for m, element := range node.Content {
+			if m > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := writeYAMLNodeAsJSON(buffer, element); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte(']')
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			buffer.WriteString("null")
+		case "!!bool":
+			var b bool
+			if err := node.Decode(&b); err != nil {
+				return err
+			}
+			fmt.Fprint(buffer, b)
+		case "!!int", "!!float":
+			// Keep decimal literals verbatim and quote the others
+			// (like 0x1F and .inf) so that they fail as numbers later.
+			if json.Valid([]byte(node.Value)) {
+				buffer.WriteString(node.Value)
+				break
+			}
+			fallthrough
+		default:
+			value, _ := json.Marshal(node.Value)
+			buffer.Write(value)
+		}
+	default:
+		return fmt.Errorf(`line %d: unsupported YAML node`, node.Line)
+	}
+	return nil
+}
+
+// WriteYAML writes output (an *Output, *Comparison, or []SweepPoint)
+// as a YAML document with the same structure as its JSON encoding
+// (see Quoting.Marshal).
+func WriteYAML(w io.Writer, output interface{}, quoting Quoting) error {
+	data, err := quoting.Marshal(output)
+	if err != nil {
+		return err
+	}
+
+	// JSON is YAML, so parse it and reformat it in YAML's block style.
+	var document yaml.Node
+	if err = yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	resetYAMLStyles(&document)
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(&document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// resetYAMLStyles recursively clears the styles of node and its children
+// so that the YAML encoder uses block style and quotes only strings
+// that would otherwise be parsed as something else.
+func resetYAMLStyles(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyles(child)
+	}
+}
@@ -5,6 +5,7 @@ go 1.18
 require (
 	github.com/johnmuirjr/go-knapsack v1.0.0
 	github.com/shopspring/decimal v1.3.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
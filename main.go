@@ -1,152 +1,279 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"github.com/johnmuirjr/go-knapsack"
+	"github.com/johnmuirjr/choose-donation-assets/donation"
 	"github.com/shopspring/decimal"
+	"io"
+	"log"
 	"os"
+	"strings"
+	"time"
 )
 
-var (
-	donation       = flag.String("donation", "1000.00", "donation amount")
-	maximizeLosses = flag.Bool("maximize-losses", false, "maximize capital losses instead of capital gains")
-	quoteDecimals  = flag.Bool("quote-decimals", false, "print decimal values as JSON strings")
-)
+// command is one run of the program:
+// the values of its flags and its standard streams.
+type command struct {
+	donationAmount   string
+	maximizeLosses   bool
+	lossCap          string
+	quoteDecimals    bool
+	quotePrices      bool
+	quoteTotals      bool
+	pretty           bool
+	sortLots         bool
+	keepAliases      bool
+	maxCells         uint64
+	maxItems         uint64
+	scaleDecimals    int
+	progress         bool
+	forceProgress    bool
+	timeout          time.Duration
+	maxPrecision     int
+	minimizeLots     bool
+	maxAmount        string
+	maxShares        string
+	maxLots          int
+	wholeLots        bool
+	fractional       bool
+	integerCents     bool
+	outputDollars    bool
+	target           string
+	feePercent       string
+	ltcgRate         string
+	stateRate        string
+	incomeRate       string
+	objective        string
+	tolerance        string
+	basisMethod      string
+	tieBreak         string
+	parallel         int
+	solver           string
+	verbose          bool
+	veryVerbose      bool
+	explain          bool
+	alternatives     int
+	altTolerance     string
+	summary          bool
+	combined         bool
+	showRemaining    bool
+	format           string
+	inputFormat      string
+	round            int
+	roundMode        string
+	roundPrices      bool
+	decimalSep       string
+	thousandsSep     string
+	errorFormat      string
+	pricesPath       string
+	rejectDups       bool
+	strict           bool
+	mergeDups        bool
+	outputPath       string
+	ndjson           bool
+	marginal         string
+	minFill          string
+	sweep            string
+	compare          bool
+	failFast         bool
+	showVersion      bool
+	check            bool
+	asOf             string
+	saleDate         string
+	longTermDays     int
+	cashFirst        bool
+	includeShortTerm bool
+	termGains        bool
+	minLotGain       string
+	perLotFee        string
+	ageWeight        string
+	inputDir         string
+	outputDir        string
+	continueOnError  bool
+	fxRate           string
+	currency         string
 
-type LotJSON struct {
-	AssetName string          `json:"assetName"`
-	Date      string          `json:"date"`
-	Shares    uint64          `json:"shares"`
-	ShareCost decimal.Decimal `json:"shareCost"`
-}
+	inputPaths, excludeAssets, onlyAssets stringList
 
-type Input struct {
-	AssetSharePrices map[string]decimal.Decimal `json:"assetSharePrices"`
-	Lots             []LotJSON                  `json:"lots"`
+	flags  *flag.FlagSet
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
 }
 
-func (i *Input) UnitCapitalGains(lot *LotJSON) decimal.Decimal {
-	return i.AssetSharePrices[lot.AssetName].Sub(lot.ShareCost)
-}
-
-type Lot struct {
-	json   *LotJSON
-	shares uint64
-	cost   uint64
+// newCommand returns a command with the standard streams
+// in, out, and errOut whose flags have their default values
+// until c.flags parses the command line.
+func newCommand(in io.Reader, out, errOut io.Writer) (c *command) {
+	c = &command{stdin: in, stdout: out, stderr: errOut}
+	c.flags = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	c.flags.SetOutput(errOut)
+	c.flags.Usage = c.printUseMessage
+	c.flags.StringVar(&c.donationAmount, "donation", "1000.00", "donation amount, or a percentage (like 5%) of the total value of all lots (overrides the input's donation)")
+	c.flags.BoolVar(&c.maximizeLosses, "maximize-losses", false, "maximize capital losses instead of capital gains")
+	c.flags.StringVar(&c.lossCap, "loss-cap", "3000", "with -maximize-losses, the capital loss beyond which the program stops adding losing shares (0 for no cap)")
+	c.flags.BoolVar(&c.quoteDecimals, "quote-decimals", false, "print decimal values as JSON strings (like -quote-prices and -quote-totals together)")
+	c.flags.BoolVar(&c.quotePrices, "quote-prices", false, "print the lots' shares, share costs, and share prices, the assetSummary's shares, and the assetSharePrices as JSON strings")
+	c.flags.BoolVar(&c.quoteTotals, "quote-totals", false, "print the decimal values other than those of -quote-prices (like totalValue and totalCapitalGains) as JSON strings")
+	c.flags.BoolVar(&c.pretty, "pretty", false, "indent the JSON output")
+	c.flags.BoolVar(&c.sortLots, "sort", false, "sort the donation lots by assetName, date, and shareCost")
+	c.flags.BoolVar(&c.keepAliases, "keep-alias-names", false, "give the output's lots their asset names from the input instead of the canonical names of aliases")
+	c.flags.Uint64Var(&c.maxCells, "max-cells", 1<<30, "maximum number of knapsack cells (items times donation) to allocate (0 for no limit)")
+	c.flags.Uint64Var(&c.maxItems, "max-items", 1<<20, "maximum number of knapsack items (about log2 of each lot's share units, or one per lot with -whole-lots) to allocate (0 for no limit)")
+	c.flags.IntVar(&c.scaleDecimals, "scale-decimals", -1, "number of decimal places to which to round share prices, costs, cash, and the donation amount before solving, which trades exactness for speed and memory (-1 for the precision of the input)")
+	c.flags.BoolVar(&c.progress, "progress", false, "show the percentage of each knapsack problem solved on standard error if it is a terminal")
+	c.flags.BoolVar(&c.forceProgress, "force-progress", false, "like -progress but even if standard error is not a terminal")
+	c.flags.DurationVar(&c.timeout, "timeout", 0, "how long to let the knapsack solvers run in total (like 30s) before stopping them and donating lots chosen greedily by capital gains per dollar instead (0 for no limit)")
+	c.flags.IntVar(&c.maxPrecision, "max-price-precision", 0, "maximum number of decimal places of share prices and costs, beyond which the program fails instead of building a huge knapsack table (0 for no limit)")
+	c.flags.BoolVar(&c.minimizeLots, "minimize-lots", false, "among the best donations, choose one with the fewest distinct lots")
+	c.flags.StringVar(&c.maxAmount, "max-amount", "1000000000000", "greatest share price, cost, cash amount, or donation amount, beyond which the program fails to catch mistyped inputs (0 for no limit)")
+	c.flags.StringVar(&c.maxShares, "max-shares", "1000000000000", "greatest number of shares in a lot, beyond which the program fails to catch mistyped inputs (0 for no limit)")
+	c.flags.IntVar(&c.maxLots, "max-lots", 0, "maximum number of distinct lots in the donation (0 for no maximum)")
+	c.flags.BoolVar(&c.wholeLots, "whole-lots", false, "donate all of a lot's shares or none of them instead of splitting lots")
+	c.flags.BoolVar(&c.fractional, "fractional", false, "quickly donate the best allocation that may donate a fraction of a share, whose capital gains are an upper bound on the exact donation's")
+	c.flags.BoolVar(&c.integerCents, "integer-cents", false, "read share prices, costs, cash, the donation, and the other options' amounts as whole numbers of cents (printing cents too unless you specify -output-dollars)")
+	c.flags.BoolVar(&c.outputDollars, "output-dollars", false, "with -integer-cents, print the output's amounts in dollars instead of cents")
+	c.flags.StringVar(&c.target, "target", "gains", "gains to maximize capital gains (or losses) or exact to get as close to the donation amount as possible")
+	c.flags.StringVar(&c.feePercent, "fee-percent", "0", "percentage of the donation amount charged as a fee, which reduces the budget for the donated lots")
+	c.flags.StringVar(&c.ltcgRate, "ltcg-rate", "0", "long-term capital gains tax rate (like 0.15) for estimating tax savings (0 for no estimate)")
+	c.flags.StringVar(&c.stateRate, "state-rate", "0", "state capital gains tax rate (like 0.05) to add to -ltcg-rate for estimating tax savings")
+	c.flags.StringVar(&c.incomeRate, "income-rate", "0", "with -maximize-losses, ordinary income tax rate (like 0.24) for estimating tax savings (0 for no estimate)")
+	c.flags.StringVar(&c.objective, "objective", "gains", "gains to maximize capital gains (or losses) or efficiency to then minimize the donation's total value")
+	c.flags.StringVar(&c.tolerance, "tolerance", "0", "with -target=exact, how far below the closest achievable value the donation may be to increase capital gains (or losses)")
+	c.flags.StringVar(&c.basisMethod, "basis-method", "", "cost basis method for breaking ties between equally good donations: fifo, lifo, hifo, or loco (default input order)")
+	c.flags.StringVar(&c.tieBreak, "tiebreak", donation.DefaultTieBreak, "rule for breaking the ties that -basis-method leaves: max-gain (lots with the largest gains per share first, the default), max-age (oldest lots first), or input (input order)")
+	c.flags.IntVar(&c.parallel, "parallel", 1, "number of goroutines that solve large problems (without -minimize-lots or -target=exact)")
+	c.flags.StringVar(&c.solver, "solver", "knapsack", "knapsack or rolling (a solver that chooses the same donation with fewer allocations and somewhat less memory, ignoring -parallel)")
+	c.flags.BoolVar(&c.verbose, "v", false, "log how the program reaches its decision (normalization, filtering, and the solver's result) to standard error")
+	c.flags.BoolVar(&c.veryVerbose, "vv", false, "like -v but also log each knapsack problem solved")
+	c.flags.BoolVar(&c.explain, "explain", false, "explain on standard error why each lot was or was not donated")
+	c.flags.IntVar(&c.alternatives, "alternatives", 0, "maximum number of alternative donations nearly as good as the donation to add to the output")
+	c.flags.StringVar(&c.altTolerance, "alternatives-tolerance", "0", "with -alternatives, how much less capital gains (or losses) the alternatives may have than the donation")
+	c.flags.BoolVar(&c.summary, "summary", false, "add the totals of donating every eligible lot (ignoring -donation) to the output")
+	c.flags.BoolVar(&c.combined, "combined", false, "add the totals of the input's alreadyDonated shares and the donation together to the output")
+	c.flags.BoolVar(&c.showRemaining, "show-remaining", false, "add the eligible lots' shares that the donation leaves to the output")
+	c.flags.StringVar(&c.format, "format", "json", "output format: json, csv, yaml, or table (aligned columns for people to read)")
+	c.flags.StringVar(&c.inputFormat, "input-format", "json", "input format: json or yaml")
+	c.flags.IntVar(&c.round, "round", -1, "number of decimal places to which to round the output's monetary totals (-1 for no rounding)")
+	c.flags.StringVar(&c.roundMode, "round-mode", "half-up", "with -round, how to round halves: half-up (away from zero) or half-even (banker's rounding)")
+	c.flags.BoolVar(&c.roundPrices, "round-prices", false, "with -round, also round the output's assetSharePrices")
+	c.flags.StringVar(&c.decimalSep, "decimal-separator", "", "decimal separator of numeric strings in the input, -prices, and -donation (like , for 1.000,50; default .)")
+	c.flags.StringVar(&c.thousandsSep, "thousands-separator", "", "thousands separator to remove from numeric strings in the input, -prices, and -donation (like . for 1.000,50; default none)")
+	c.flags.StringVar(&c.errorFormat, "error-format", "text", "text or json (an object with error and code fields) for the errors the program prints on standard error")
+	c.flags.StringVar(&c.pricesPath, "prices", "", "path of a CSV file with assetName and sharePrice columns whose prices override the input's assetSharePrices")
+	c.flags.BoolVar(&c.rejectDups, "reject-duplicates", false, "fail if two lots have the same assetName, date, and shareCost")
+	c.flags.BoolVar(&c.strict, "strict", false, "fail if assetSharePrices has assets that no lot has (instead of noting them on standard error)")
+	c.flags.BoolVar(&c.mergeDups, "merge-duplicates", false, "merge lots with the same assetName, date, and shareCost by adding their shares")
+	c.flags.StringVar(&c.outputPath, "output", "-", "path of the output JSON file (- for standard output)")
+	c.flags.BoolVar(&c.ndjson, "ndjson", false, "read one input JSON object per line of standard input and write one output JSON object per line")
+	c.flags.StringVar(&c.marginal, "marginal", "0", "extra donation amount with which to estimate how much more capital gains (or losses) each extra dollar would capture (0 for no estimate)")
+	c.flags.StringVar(&c.minFill, "min-fill", "0", "smallest ratio (like 0.9) of the donation's total value to the donation amount, below which the program exits with status 4 (0 for no minimum)")
+	c.flags.StringVar(&c.sweep, "sweep", "", "start:end:step range of donation amounts for which to print the totals of the best donations instead of one donation")
+	c.flags.BoolVar(&c.compare, "compare", false, "print both the donation that maximizes capital gains and the one that maximizes capital losses (ignoring -maximize-losses)")
+	c.flags.BoolVar(&c.failFast, "fail-fast", false, "with -ndjson, stop at the first line that fails")
+	c.flags.BoolVar(&c.showVersion, "version", false, "print the program's version, git commit, and build date and exit")
+	c.flags.BoolVar(&c.check, "check", false, "validate the input and the options and print a summary of the input instead of a donation")
+	c.flags.StringVar(&c.asOf, "as-of", "", "date (YYYY-MM-DD) against which holding periods are computed (default today)")
+	c.flags.StringVar(&c.saleDate, "sale-date", "", "with -maximize-losses, date (YYYY-MM-DD) on which the lots will be sold, for detecting wash sales (default the -as-of date)")
+	c.flags.IntVar(&c.longTermDays, "long-term-days", 0, "number of calendar days a lot must be held to be long-term (0 for more than one year)")
+	c.flags.BoolVar(&c.cashFirst, "cash-first", false, "donate the cash lots before choosing other lots with the rest of the budget")
+	c.flags.BoolVar(&c.includeShortTerm, "include-short-term", false, "consider lots that are not long-term when maximizing capital gains")
+	c.flags.BoolVar(&c.termGains, "term-gains", false, "add the donation's long-term and short-term capital gains to the output")
+	c.flags.StringVar(&c.minLotGain, "min-lot-gain", "0", "smallest total capital gain (or loss with -maximize-losses) of a lot's donatable shares for the lot to be donated (0 for no minimum)")
+	c.flags.StringVar(&c.perLotFee, "per-lot-fee", "0", "fee for each distinct lot donated, which the program subtracts from the capital gains (or losses) it maximizes to donate fewer lots (0 for no fee)")
+	c.flags.StringVar(&c.ageWeight, "age-weight", "0", "bonus for each year a lot has been held (like 0.001 for 0.1% of its capital gains, or losses, per year) that favors older lots among donations with similar capital gains (0 for no bonus)")
+	c.flags.StringVar(&c.inputDir, "input-dir", "", "path of a directory whose *.json input files to solve independently, writing each output to -output-dir")
+	c.flags.StringVar(&c.outputDir, "output-dir", "", "with -input-dir, path of the directory (created if missing) in which to write each input file's output as <name>.out.json")
+	c.flags.BoolVar(&c.continueOnError, "continue-on-error", false, "with -input-dir, exit with status 0 even if some files fail")
+	c.flags.StringVar(&c.fxRate, "fx-rate", "0", "number of units of -currency per unit of the input's currency by which to multiply the output's prices, costs, and totals after solving (0 for no conversion)")
+	c.flags.StringVar(&c.currency, "currency", "", "with -fx-rate, name of the currency (like EUR) of the converted output")
+	c.flags.Var(&c.inputPaths, "input", "path of an input JSON file (- for standard input); repeat to merge several files (default standard input)")
+	c.flags.Var(&c.excludeAssets, "exclude", "name of an asset whose lots not to donate; repeat to exclude several assets")
+	c.flags.Var(&c.onlyAssets, "only", "name of the only asset whose lots to donate (unless it is also excluded by -exclude); repeat to allow several assets")
+	return
 }
 
-type NormalizedLots struct {
-	lots     []Lot
-	donation uint64
+// stringList is a flag.Value that collects the values of a repeated flag.
+type stringList []string
 
-	// minimum exponent from AssetSharePrices
-	sharePriceExponent int32
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
 
-	// AssetSharePrices converted to integers
-	// after shifting by -sharePriceExponent
-	// (to make the knapsack algorithm work)
-	sharePrices map[string]uint64
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
-func NewNormalizedLots(input *Input, donation string) (nl NormalizedLots, err error) {
-	donationDecimal := decimal.RequireFromString(donation)
-	nl.sharePriceExponent = donationDecimal.Exponent()
-	for _, lot := range input.Lots {
-		if lot.ShareCost.Exponent() < nl.sharePriceExponent {
-			nl.sharePriceExponent = lot.ShareCost.Exponent()
-		}
-		if _, ok := input.AssetSharePrices[lot.AssetName]; !ok {
-			err = fmt.Errorf(`lot has an assetName that does not appear in assetSharePrices: %s`, lot.AssetName)
+// readInput decodes the input JSON file at path
+// (or standard input if path is "-").
+func (c *command) readInput(path string) (input donation.Input, err error) {
+	inputFile, name := c.stdin, "standard input"
+	if path != "-" {
+		name = path
+		var file *os.File
+		if file, err = os.Open(path); err != nil {
+			err = fmt.Errorf("error opening input file %s: %w", path, err)
 			return
 		}
+		defer file.Close()
+		inputFile = file
 	}
-	for _, value := range input.AssetSharePrices {
-		if value.Exponent() < nl.sharePriceExponent {
-			nl.sharePriceExponent = value.Exponent()
-		}
-	}
-
-	nl.donation = uint64(donationDecimal.Shift(-nl.sharePriceExponent).IntPart())
-	nl.lots = make([]Lot, len(input.Lots))
-	for m := range input.Lots {
-		nl.lots[m] = Lot{
-			json:   &input.Lots[m],
-			shares: input.Lots[m].Shares,
-			cost:   uint64(input.Lots[m].ShareCost.Shift(-nl.sharePriceExponent).IntPart())}
-	}
-	nl.sharePrices = make(map[string]uint64, len(input.AssetSharePrices))
-	for name, value := range input.AssetSharePrices {
-		nl.sharePrices[name] = uint64(value.Shift(-nl.sharePriceExponent).IntPart())
+	data, err := io.ReadAll(inputFile)
+	if err != nil {
+		err = fmt.Errorf("error reading input from %s: %w", name, err)
+		return
 	}
-	return
-}
-
-func (na *NormalizedLots) UnitCapitalGains(lot *Lot) int64 {
-	return int64(na.sharePrices[lot.json.AssetName]) - int64(lot.cost)
-}
-
-func (nl *NormalizedLots) FilterLotsInPlace() {
-	length := len(nl.lots)
-	filter := func(lot *Lot) bool {
-		if *maximizeLosses {
-			return nl.UnitCapitalGains(lot) < 0
+	if c.inputFormat == "yaml" {
+		if data, err = donation.YAMLToJSON(data); err != nil {
+			err = fmt.Errorf("error decoding input YAML from %s: %w", name, err)
+			return
 		}
-		return nl.UnitCapitalGains(lot) > 0
 	}
-	for m := 0; m < length; {
-		if filter(&nl.lots[m]) && nl.lots[m].shares > 0 && nl.sharePrices[nl.lots[m].json.AssetName] <= nl.donation {
-			m++
-		} else {
-			length--
-			nl.lots[m] = nl.lots[length]
-		}
+	if data, err = c.inputSeparators().NormalizeJSON(data); err != nil {
+		err = fmt.Errorf("invalid input in %s: %w", name, err)
+		return
 	}
-	nl.lots = nl.lots[:length]
-}
-
-func (nl *NormalizedLots) GetTotalPrice() (totalPrice uint64) {
-	for _, lot := range nl.lots {
-		totalPrice += nl.sharePrices[lot.json.AssetName] * lot.shares
+	if err = donation.ValidateJSON(data); err != nil {
+		err = fmt.Errorf("invalid input in %s: %w", name, err)
+		return
+	}
+	if err = json.Unmarshal(data, &input); err != nil {
+		err = fmt.Errorf("error decoding input JSON from %s: %w", name, err)
 	}
 	return
 }
 
-func ExpandLots(unexpanded []Lot) (expanded []Lot) {
-	numShares := uint64(0)
-	for _, lot := range unexpanded {
-		numShares += lot.shares
+// readPrices overrides input's share prices
+// with those in the CSV file at path.
+func (c *command) readPrices(path string, input *donation.Input) error {
+	pricesFile, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening prices file %s: %w", path, err)
 	}
-	expanded = make([]Lot, numShares)[:0]
-	for _, lot := range unexpanded {
-		for n := uint64(0); n < lot.shares; n++ {
-			expanded = append(expanded, lot)
-		}
+	defer pricesFile.Close()
+	prices, err := donation.ReadPricesCSV(pricesFile, c.inputSeparators())
+	if err != nil {
+		return fmt.Errorf("invalid prices in %s: %w", path, err)
 	}
-	return
-}
-
-func DeduplicateLots(lots []Lot) (deduplicated []Lot) {
-	deduplicated = make([]Lot, len(lots))[:0]
-	var prev *LotJSON
-	for m, lot := range lots {
-		if prev != nil && lot.json == prev {
-			deduplicated[len(deduplicated)-1].shares++
-			continue
-		}
-		prev = lots[m].json
-		d := lots[m]
-		d.shares = 1
-		deduplicated = append(deduplicated, d)
+	if input.AssetSharePrices == nil {
+		input.AssetSharePrices = make(map[string]decimal.Decimal, len(prices))
 	}
-	return
+	for name, price := range prices {
+		input.AssetSharePrices[name] = price
+	}
+	return nil
 }
 
-func printUseMessage() {
-	fmt.Fprintf(os.Stderr,
+func (c *command) printUseMessage() {
+	fmt.Fprintf(c.stderr,
 		`choose-donation-assets reads a set of asset prices and lots
-from standard input and calculates which lots you should donate
+from standard input (or the files named by -input)
+and calculates which lots you should donate
 to maximize capital gains tax savings (or, optionally,
 which you should sell before donating to maximize capital losses).
 
@@ -170,109 +297,1429 @@ This tool helps calculate which assets you should donate to charity
 given one of these two goals and a donation amount.
 The goal is to encourage more charitable giving
 and save you taxes in the long run.
+(Without -maximize-losses, the program warns on standard error
+if more than half of the lots have no capital gains,
+since you may want the second goal instead.)
 
-Standard input MUST be a JSON object with the following structure:
+The input MUST be a JSON object with the following structure.
+(If you specify -input more than once, the program merges the files
+by concatenating their lots and combining their assetSharePrices.
+An asset may appear in several files' assetSharePrices
+only if all of them specify the same price.)
 
 - assetSharePrices :: object -- a set of current share (per-unit) prices
   for assets, where each key is the case-sensitive name of an asset
-  and the value is the current share (per-unit) price of that asset,
-  which can be a number or a numeric string
+  and the value is the current nonnegative share (per-unit) price
+  of that asset, which can be a number or a numeric string
+  (The program notes assets that no lot has on standard error,
+  or fails with -strict, since they may indicate a mistake.)
+  -prices names a CSV file with a header record and assetName
+  and sharePrice columns (in any order) whose prices override
+  any of assetSharePrices in the input, so that you can keep
+  stable lots and daily prices in separate files
+  (assetSharePrices is optional, but every lot without a sharePrice
+  needs a price from it or -prices, so only an input without lots
+  like {} can omit both; it produces an empty donation)
 - lots :: array -- a list of asset lots, each of which is an object
   with the following fields:
     - assetName :: string -- the asset's case-sensitive name,
       which must match a key in assetSharePrices above
-    - date :: string -- the date the asset was acquired
-      (used for identifying this lot, so it can be any value
-      that helps you easily identify it)
-    - shares :: int -- the positive number of shares of this asset
-      in this lot
-    - shareCost :: number|numericString -- the share (per-unit) cost
-      of the asset in this lot (the price of the asset
+      (unless the lot has a sharePrice)
+    - date :: string -- the date the asset was acquired,
+      formatted as YYYY-MM-DD or as an RFC 3339 timestamp
+      (used for identifying this lot and for computing
+      how long you have held it)
+    - shares :: number|numericString -- the positive number of shares
+      of this asset in this lot, which can be fractional
+    - shareCost :: number|numericString -- the nonnegative share
+      (per-unit) cost of the asset in this lot (the price of the asset
       when you purchased it in this lot), which can be a number
       or a numeric string
+    - maxDonatableShares :: number|numericString -- (optional)
+      the maximum number of shares of this lot to donate
+      (for example, to keep some shares of this lot)
+    - sharePrice :: number|numericString -- (optional)
+      the current nonnegative share price of this lot if it differs from
+      its asset's price in assetSharePrices (for example,
+      for a restricted tranche); a lot with a sharePrice
+      need not have its assetName in assetSharePrices
+    - cash :: bool -- (optional) whether the lot is cash
+      (like the proceeds of lots you already sold) whose shares
+      are its amount; cash has a share price of 1 and no capital gains,
+      so its shareCost is optional and it ignores sharePrice (see below)
+    - pinned :: bool -- (optional) whether to donate all of the lot's
+      shares (or maxDonatableShares) regardless of -exclude, -only,
+      and the other reasons for excluding lots, like a lot your
+      accountant chose by specific identification; the program
+      optimizes what remains of the budget around the pinned lots
+      and fails if they alone exceed the budget
+      (pinned lots stay pinned in the output)
+    - any other fields (like account numbers, CUSIPs, or lot IDs),
+      which the program ignores but copies to the lot in the output
+- charities :: array -- (optional) a list of charities
+  among which to split the donation (see below),
+  each of which is an object with the following fields:
+    - name :: string -- the charity's name
+    - budget :: number|numericString -- the positive amount
+      to donate to the charity
+- recentPurchases :: array -- (optional, only used with -maximize-losses)
+  a list of recent purchases of assets, each of which is an object
+  with the following fields:
+    - assetName :: string -- the purchased asset's case-sensitive name
+    - date :: string -- the date of the purchase,
+      formatted like the lots' dates
+- donation :: number|string -- (optional) the donation amount
+  (like the -donation value, so it can be a percentage like "5%%"),
+  which makes the input a self-contained scenario:
+  -donation on the command line overrides it,
+  and the program uses the default -donation value
+  only if neither is present
+  (merged inputs must not have different donation amounts)
+- aliases :: object -- (optional) alternative asset names (keys)
+  for the assets in assetSharePrices (values), so that lots
+  with different names for the same asset (like a ticker
+  and a fund name) share its price; the output's lots have the
+  canonical names unless you pass -keep-alias-names,
+  and aliases must not be keys of assetSharePrices or other aliases
+  (merged inputs must not give an alias different canonical names)
+- alreadyDonated :: array -- (optional) shares you already donated
+  this year (for a top-up donation), which the program subtracts
+  from the lots before optimizing, each of which is an object
+  with the following fields:
+    - assetName :: string -- the donated asset's case-sensitive name
+    - date :: string -- the date on which the donated lot was acquired,
+      formatted like the lots' dates
+    - shares :: number|numericString -- the positive number
+      of donated shares, which the program takes from the first lots
+      with that assetName and date (and shareCost, if present);
+      the program fails if they exceed those lots' shares
+    - shareCost :: number|numericString -- (optional) the donated lot's
+      share cost, which tells it apart from other lots
+      with the same assetName and date
 
-The program prints the results to standard output,
-which is a JSON object with the following structure:
+The program prints the results to standard output
+(or the file named by -output),
+which is a JSON object with the following structure
+(whose fields always appear in this order, with the keys
+of assetSharePrices and assetSummary sorted in byte order,
+so the same input, options, and date always produce the same output,
+and with its decimal values as JSON numbers unless you specify
+-quote-prices to print the lots' shares, shareCosts, sharePrices,
+maxDonatableShares, and originalShares, the assetSummary's shares,
+and the assetSharePrices as numeric strings,
+-quote-totals to print the other decimal values (like totalValue,
+totalCapitalGains, and the lots' capitalGains) as numeric strings,
+or -quote-decimals to do both, so that JSON parsers that read
+numbers as floating-point numbers do not round them):
 
 - donation :: object -- the lots you should donate,
   which have the same structure as the lots objects
-  from standard input (but note that the number of shares
-  you should donate in each lot may differ from those you inputted)
-- assetSharePrices :: object -- the same assetSharePrices from standard input
+  from the input (but note that the number of shares
+  you should donate in each lot may differ from those you inputted,
+  and the lots' other fields follow their known fields in sorted order)
+  plus the following fields:
+    - longTerm :: bool -- whether you have held the lot
+      long enough to be long-term (see -long-term-days below)
+    - originalShares :: number|numericString -- the number of shares
+      that the lot has in the input (even if you should donate all of them)
+    - capitalGains :: number|numericString -- the capital gains
+      (or losses if negative) of the shares you should donate,
+      which add up to totalCapitalGains (except for rounding)
+    - partial :: bool -- (only present if true) whether you should
+      donate only some of the lot's shares instead of emptying it
+- assetSharePrices :: object -- the same assetSharePrices from the input
+- donationAmount :: number|numericString -- the donation amount
+  (the -donation value, or, if -donation is a percentage,
+  that percentage of the total value of all lots
+  rounded down to the precision of the prices and costs)
 - totalValue :: number|numericString -- the total value (total price)
   of the assets in the donation
 - totalCapitalGains :: number|numericString -- the total capital gains
   (or losses if negative) contained in the donation
+- longTermGains, shortTermGains :: number|numericString --
+  (only with -term-gains) the parts of totalCapitalGains
+  in long-term and short-term lots, which are taxed differently
+  and always add up to totalCapitalGains (even with -round);
+  without -include-short-term, a donation that maximizes
+  capital gains only has long-term lots, whose gains
+  donating avoids entirely, so shortTermGains is zero
+- remainingBudget :: number|numericString -- the donation amount
+  (or budget with -fee-percent) minus totalValue (the part
+  of the donation amount that the donation does not use)
+- budget :: number|numericString -- (only with -fee-percent)
+  the donation amount minus -fee-percent percent of it,
+  rounded down to the precision of the prices, costs, and shares,
+  which is the most that the donation's totalValue can be
+- gainsRatio :: number|numericString -- totalCapitalGains divided by
+  totalValue (the capital gains you donate per unit of value)
+  or zero if totalValue is zero
+- assetSummary :: object -- the donation's totals for each asset,
+  where each key is an asset's name and the value is an object
+  with the following fields:
+    - shares :: number|numericString -- the total number of shares
+      of the asset in the donation
+    - totalValue :: number|numericString -- the total value
+      of the asset's shares in the donation
+    - totalCapitalGains :: number|numericString -- the total capital
+      gains (or losses if negative) of the asset's shares in the donation
+- excludedLots :: array -- the lots from the input (in input order)
+  that the program could not donate, each with the same structure
+  as the lots objects from the input plus the following field:
+    - reason :: string -- why the program could not donate the lot:
+        - excludedAsset -- the lot's asset was named by -exclude
+        - notSelected -- the lot's asset was not named by -only
+          (if you specified -only)
+        - noCapitalGains -- the lot has no capital gains
+        - noCapitalLosses -- the lot has no capital losses
+          (with -maximize-losses)
+        - shortTerm -- the lot is not long-term
+          (see -include-short-term)
+        - washSale -- selling the lot at a loss would be a wash sale
+          because of a recent purchase of its asset
+          (with -maximize-losses; see below)
+        - noShares -- the lot has no shares to donate
+          (because its maxDonatableShares is zero)
+        - belowMinLotGain -- the total capital gains (or losses
+          with -maximize-losses) of the lot's donatable shares
+          are less than -min-lot-gain
+        - priceExceedsDonation -- a single share of the lot's asset
+          costs more than the donation amount
+  (omitted if the program could donate all lots)
+- remainingLots :: array -- (only with -show-remaining) the lots
+  from the input (in input order) that are not in excludedLots
+  and that the donation does not empty, with the same structure
+  as the lots objects from the input but with only the shares
+  (and maxDonatableShares) that the donation leaves, so you can see
+  what is left for future donations or sales
+  (or record the donation in alreadyDonated and run the program again)
+- eligible :: object -- (only with -summary) the baseline of donating
+  every lot that is not in excludedLots regardless of the donation amount
+  (a ceiling for the donation above), with the following fields:
+    - lots :: number -- the number of eligible lots
+    - totalValue :: number|numericString -- the total value
+      of the eligible lots' shares (up to their maxDonatableShares)
+    - totalCapitalGains :: number|numericString -- the total capital
+      gains (or losses if negative) of those shares
+- alternatives :: array -- (only with -alternatives)
+  other donations whose totalCapitalGains are at most
+  -alternatives-tolerance less than the donation's
+  (or, with -maximize-losses, whose losses are at most that much smaller),
+  from the best to the worst, each of which is an object with the fields
+  donation, totalValue, totalCapitalGains, remainingBudget,
+  and assetSummary (like the fields above)
+  (omitted if there are no such donations)
+- charities :: array -- (only if the input has charities)
+  the donation to each charity in input order, each of which
+  is an object with the following fields:
+    - name :: string -- the charity's name
+    - donation, donationAmount, totalValue, totalCapitalGains,
+      remainingBudget, assetSummary -- like the fields above
+      but only for the charity's part of the donation
+- lossCap :: number|numericString -- the -loss-cap value
+  (only present with -maximize-losses and a nonzero -loss-cap)
+- excessLoss :: number|numericString -- the amount by which
+  the donation's capital losses exceed lossCap
+  (only present with lossCap)
+- estimatedTaxSavings :: number|numericString -- (only with -ltcg-rate
+  or -state-rate, or -income-rate with -maximize-losses) a rough estimate
+  of the taxes the donation saves (see below)
+- marginal :: object -- (only with -marginal, and not if the input
+  has charities) an estimate of the "shadow price" of the budget:
+  how much more capital gains (or losses) a larger donation would capture,
+  with the following fields:
+    - step :: number|numericString -- the -marginal value
+    - totalCapitalGains :: number|numericString -- the totalCapitalGains
+      of the best donation of donationAmount plus step
+    - gainsPerDollar :: number|numericString -- the extra capital gains
+      (or losses if negative) per dollar of step, which, if it is large,
+      suggests that you try a larger donation amount
+    - approximate :: bool -- (only present if true) whether
+      the larger donation is a greedy one because -timeout passed
+- combined :: object -- (only with -combined) the totals
+  of the alreadyDonated shares (at the current share prices)
+  and the donation together, with the fields
+  totalValue and totalCapitalGains
+- maxLotsReached :: bool -- (only present if true) whether the best
+  donation had more than -max-lots lots, so the donation is the best
+  of at most -max-lots lots instead (see below)
+- approximate :: bool -- (only present if true) whether the solver
+  did not finish within -timeout, so the donation is a greedy one
+  that may not be optimal (and there are no alternatives,
+  which are also left out if -timeout passes while finding them)
+- fractional :: bool -- (only with -fractional) whether the donation
+  may donate fractional shares (see below), so its totalCapitalGains
+  is an upper bound on those of the exact donation
+- currency :: string -- (only with -fx-rate and -currency) the -currency
+  of the output's monetary values
+- fxRate :: number|numericString -- (only with -fx-rate) the -fx-rate
+  by which the program multiplied the output's monetary values
+- config :: object -- the effective configuration that produced
+  the output (so that saved outputs describe themselves),
+  with the following fields:
+    - donationAmount :: number|numericString -- the donationAmount above
+      (with percentages resolved)
+    - donation :: string -- the -donation value
+      (omitted if the input has charities)
+    - feePercent :: number|numericString -- -fee-percent
+      (only present if it is not zero)
+    - ltcgRate, stateRate, incomeRate :: number|numericString --
+      -ltcg-rate, -state-rate, and -income-rate
+      (only present if they are not zero)
+    - maximizeLosses :: bool -- -maximize-losses
+    - lossCap :: number|numericString -- -loss-cap
+      (only present with -maximize-losses)
+    - saleDate :: string -- the -sale-date (YYYY-MM-DD)
+      (only present with -maximize-losses)
+    - minLotGain :: number|numericString -- -min-lot-gain
+      (only present if it is not zero)
+    - perLotFee :: number|numericString -- -per-lot-fee
+      (only present if it is not zero)
+    - maxLots :: number -- -max-lots (only present if it is positive)
+    - ageWeight :: number|numericString -- -age-weight
+      (only present if it is positive)
+    - scaleDecimals :: number -- -scale-decimals, the number of decimal
+      places of the prices and costs with which the program solved
+      (only present with -scale-decimals)
+    - integerCents :: bool -- -integer-cents
+      (only present with -integer-cents)
+    - asOf :: string -- the -as-of date (YYYY-MM-DD)
+    - longTermDays :: number -- -long-term-days
+    - includeShortTerm :: bool -- -include-short-term
+    - cashFirst :: bool -- -cash-first
+    - target :: string -- -target
+    - objective :: string -- -objective
+    - tolerance :: number|numericString -- -tolerance
+      (only present with -target=exact)
+    - excludeAssets :: array -- the -exclude assets (omitted if none)
+    - onlyAssets :: array -- the -only assets (omitted if none)
+    - minimizeLots :: bool -- -minimize-lots
+    - wholeLots :: bool -- -whole-lots
+    - fractional :: bool -- -fractional (only present if true)
+    - basisMethod :: string -- -basis-method (empty for input order)
+    - tieBreak :: string -- -tiebreak
+    - sort :: bool -- -sort
+    - strict :: bool -- -strict
+    - rejectDuplicates, mergeDuplicates :: bool --
+      -reject-duplicates and -merge-duplicates
+
+Lots with the same assetName, date, and shareCost (for example,
+from pasting overlapping exports, which doubles their shares)
+are normally treated as separate lots.
+-reject-duplicates makes the program fail if it finds any such lots.
+-merge-duplicates instead merges each set of them into one lot
+whose shares (and maxDonatableShares, if all of them have one)
+are the sum of theirs.
+Merging compares dates as written in the input,
+so it only merges lots whose date strings match exactly
+(2019-01-02 and 2019-01-02T00:00:00Z remain separate lots).
+
+If you specify -ltcg-rate, the program estimates the taxes
+that donating the capital gains saves as totalCapitalGains times the rate
+plus -state-rate, the rate of any state tax on capital gains
+(which you can also specify alone).
+With -maximize-losses and -income-rate, it instead estimates the taxes
+that deducting the capital losses saves as the losses
+(up to lossCap if there is one) times the rate.
+These estimates are simplistic: they assume that you would otherwise
+sell the donated assets (or would not otherwise realize losses),
+that all of the gains are taxed at -ltcg-rate plus -state-rate,
+and that all of the deducted losses offset income taxed at -income-rate.
+They ignore your other gains and losses, loss carryovers,
+phase-outs (including of deductions), the net investment income tax,
+state taxes on income,
+and the value of the charitable deduction itself.
+
+-alternatives=N asks for up to N alternatives to the donation
+for choosing among donations for other reasons.
+The program finds them by choosing the best donation again
+without each lot of the donation in turn,
+so each alternative lacks at least one of the donation's lots
+and the search takes up to one extra solution per donated lot.
+Alternatives ignore -explain and inputs with charities.
+
+If a donor-advised fund charges a fee, pass its percentage
+as -fee-percent so that the donated lots plus the fee
+do not exceed the donation amount.
+(With charities, the fee applies to each charity's budget.)
+
+If the input has charities, the program ignores -donation
+and instead donates to each charity in input order
+the best donation of up to its budget from the shares
+that earlier charities did not receive (so no share is donated twice).
+This greedy approach does not always maximize the capital gains
+(or losses) of the combined donation but is usually close.
+The top-level donation, totals, and assetSummary then combine
+the charities' donations, donationAmount is the sum of their budgets,
+excludedLots lists the lots that the program could not donate
+to any charity, and -loss-cap applies to the combined donation.
+(The CSV output only contains the combined donation.)
+
+The IRS disallows a loss from selling an asset if you buy
+the same (or a substantially identical) asset within 30 days
+before or after the sale (a wash sale).
+With -maximize-losses, the program excludes the lots of every asset
+that has a recentPurchases entry at most 30 calendar days
+before or after -sale-date (which defaults to today or the -as-of date).
+List substantially identical assets' purchases under each asset's name.
+
+A lot is long-term if, as of today (or the -as-of date),
+it has been held for more than one year: by default,
+a lot acquired on 2020-01-01 is short-term on 2021-01-01
+(exactly one year later, even though that is 366 days in a leap year)
+and long-term on 2021-01-02.
+With a positive -long-term-days, a lot is instead long-term
+if at least that many calendar days have passed since its date,
+ignoring times of day (so the boundary is inclusive:
+with -long-term-days=366, a lot acquired on 2021-01-01
+is short-term on 2022-01-01, 365 days later,
+and long-term on 2022-01-02, 366 days later).
+When maximizing capital gains, the program ignores lots
+that are not long-term (which do not qualify for the first rule above)
+unless you specify -include-short-term.
+
+If you specify -format=csv, the program instead prints the donation lots
+as CSV with the columns assetName, date, shares, shareCost, sharePrice,
+value (the donated shares' total price), and capitalGains,
+followed by a "total" row containing totalValue and totalCapitalGains.
+(-quote-decimals, -quote-prices, and -quote-totals do not affect CSV output.)
+If you specify -format=table, the program instead prints the donation lots
+as a table with aligned columns for people to read (asset, date, shares,
+share price, unit gain, value, and capital gains, with the monetary
+columns rounded as -round specifies) followed by a TOTAL row.
+
+If you specify -input-format=yaml, the inputs MUST instead be YAML
+documents with the same structure as the JSON input above.
+If you specify -format=yaml, the program prints the output
+as a YAML document with the same structure as the JSON output
+(with decimal values as YAML strings if you specify -quote-decimals,
+-quote-prices, or -quote-totals).
+Numbers and dates mean the same in YAML as in JSON;
+in particular, numbers keep all of their decimal places.
+
+If you specify -round, the program rounds donationAmount, totalValue,
+totalCapitalGains, longTermGains, shortTermGains, remainingBudget,
+budget, lossCap, excessLoss, estimatedTaxSavings, and the totals
+in assetSummary, eligible, alternatives, charities, marginal,
+and combined (and, with -round-prices, assetSharePrices) to that many decimal places after choosing the donation,
+so rounding never affects which lots the program chooses.
+-round-mode chooses whether halves round away from zero (half-up)
+or to the nearest even digit (half-even).
+The CSV value and capitalGains columns of individual lots are not rounded
+(but those of -format=table are).
+
+When maximizing capital losses, the program stops adding losing shares
+to the donation once their total losses reach -loss-cap
+(by default $3,000, the usual annual limit on deducting
+net capital losses from gross income), preferring shares
+with the largest losses.  Losses beyond the cap are not wasted
+(they usually carry forward to later tax years),
+but realizing them now is not useful.  The cap ignores
+any capital gains the losses could offset,
+so raise it if you have such gains.
 
 The program will not exceed the specified donation amount;
 therefore, if you are comfortable donating slightly more
 than your target donation amount, try various larger ones
-until you find a donation that satisfies you.
+(all at once with -sweep) until you find a donation that satisfies you;
+-marginal tells you whether a slightly larger one is likely to help.
+Conversely, if the lots may not be worth nearly the donation amount,
+-min-fill makes the program exit with status 4
+(after printing the donation and, on standard error,
+how much of the donation amount it could fill)
+when the ratio of totalValue to donationAmount is below its value,
+so that automated pipelines do not proceed with a poor donation
+(it does not work with -compare, -sweep, or -ndjson).
+
+With -whole-lots, the program donates each lot entirely or not at all
+(all of its maxDonatableShares if it has them, and the whole amount
+of cash), which simplifies record keeping and is faster because
+each lot is one knapsack item instead of several,
+though it usually leaves more of the budget unused.
+With -maximize-losses, the last lot that reaches -loss-cap
+is also donated entirely, so excessLoss may be positive.
+
+With -minimize-lots, the program chooses the donation with the fewest
+distinct lots among those with the greatest capital gains (or losses).
+It never sacrifices capital gains (or losses) to donate fewer lots,
+so it only changes the donation when several donations are equally good.
+
+If your donor-advised fund accepts only so many lots in one gift,
+-max-lots limits the donation (and each alternative) to that many
+distinct lots, counting cash and pinned lots.  If the best donation
+has more, the program solves again for the best donation
+of at most -max-lots lots (which may leave out lots of the first one)
+and sets maxLotsReached in the output.  That solve uses the solver
+of -minimize-lots with a table for each number of lots up to -max-lots,
+so it takes about -max-lots times as long and as much memory
+(and counts that many times the cells against -max-cells).
+Cash fills what the other lots leave only if the limit allows
+another lot.  With charities, the limit applies to each charity's donation.
+
+If your brokerage charges a fee for each lot it transfers,
+-per-lot-fee makes the program maximize the total capital gains
+(or losses) minus the fee times the number of distinct lots
+donated (other than cash and pinned lots), so it only donates
+another lot if the lot's shares capture more than the fee
+and may leave part of the budget unused rather than split
+the donation among many small lots.  For example, with a fee of 5,
+the program prefers one lot with capital gains of 40 to three lots
+with capital gains of 15 each.  The fee does not reduce the budget
+(use -fee-percent for fees paid out of the donation),
+and -per-lot-fee uses the solver of -minimize-lots.
+
+If you would rather donate the lots you have held longest
+(say, for estate planning) when doing so costs little,
+-age-weight makes the program maximize the capital gains (or losses)
+of each lot times 1 plus the -age-weight for each year (of 365 days)
+the lot has been held as of the -as-of date, which favors older lots
+among donations with similar capital gains (or losses).
+The bonus is proportional to the gains, so a donation
+with materially larger capital gains always wins:
+with -age-weight=0.001, a lot held for ten years beats
+a lot held for one year with up to about 0.9%% more capital gains
+but not one with 1%% more.  The output still reports
+the actual capital gains, and the greedy choice of -timeout
+ignores -age-weight.
+
+With -target=exact, the program instead chooses the donation
+whose totalValue is as close to the donation amount as possible
+(see remainingBudget) without exceeding it, maximizing capital gains
+(or losses) among such donations.  Specify -tolerance to allow
+donations whose totalValue is up to that amount below the closest one
+if they have greater capital gains (or losses).
+The program still only donates lots with capital gains
+(or losses with -maximize-losses).
+
+Cash lots (see cash above) add nothing to the capital gains
+(or losses) that the program maximizes, so they never displace
+other lots: the program chooses the other lots as if the cash
+did not exist and then fills as much of the remaining budget
+as it can with cash (in input order).  With -cash-first,
+it instead donates as much cash as the budget allows first
+and chooses the other lots with what remains,
+which donates the cash even if it leaves less budget
+for lots with capital gains (or losses).
+Cash is eligible regardless of its date, -maximize-losses,
+-include-short-term, -min-lot-gain, and recent purchases,
+but -exclude and -only apply to its assetName,
+and the decimal places of its amount count as
+those of prices (see below).
+
+With -objective=efficiency, the program chooses, among the donations
+with the greatest capital gains (or losses), the one with the smallest
+totalValue (and thus the greatest gainsRatio), leaving the rest
+of the budget in remainingBudget.  The default -objective=gains already
+maximizes the total capital gains (or losses) that fit in the budget,
+so no donation ever has greater gains per dollar and also greater gains;
+efficiency only decides among equally good donations,
+such as donating 5 shares with a unit gain of 10 and a price of 10
+instead of 1 share with a unit gain of 50 and a price of 55
+given a donation amount of 55.
+Its knapsack values are larger, so it fails on problems
+whose total capital gains times the normalized donation overflow.
+It applies before -minimize-lots and -basis-method.
+
+When several donations are equally good, the program prefers lots
+by -basis-method if you specify it:
+
+- fifo -- prefer the oldest lots
+- lifo -- prefer the newest lots
+- hifo -- prefer the lots with the highest share costs
+- loco -- prefer the lots with the lowest share costs
+
+Because the program always prefers lots with greater capital gains
+(or, with -maximize-losses, greater capital losses) per share,
+the method only matters between lots (typically of different assets)
+whose shares are equally valuable to the donation.
+For example, two lots of the same asset with the same share cost
+tie, but a lot with a higher share cost has smaller gains (larger losses)
+and is always less (more) preferable regardless of the method.
+
+Among lots that the method considers equal (or all lots without it),
+the program prefers lots by -tiebreak:
+
+- max-gain -- (the default) prefer the lots with the largest
+  capital gains (or, with -maximize-losses, losses) per share,
+  like the lowest-cost lots of an asset
+- max-age -- prefer the oldest lots
+- input -- prefer the lots that appear earlier in the input
+
+For example, if the budget buys either one share with a capital gain
+of 30 or two shares with capital gains of 10 and 20, both donations
+capture 30, and max-gain donates the former.
+
+Ties are broken deterministically: the program keeps the eligible lots
+in the -basis-method and -tiebreak order (which keeps input order
+among lots that both consider equal), and its solvers keep
+the first of several equally good donations they find,
+so repeated runs with the same input, options, and -as-of date
+produce byte-for-byte identical output.
+
+The core algorithm splits each lot into O(log(shares)) knapsack items
+(of 1, 2, 4, ... shares) and runs in O(i*d) time and takes O(d + i*d/64)
+space (a bit set of the chosen items for each capacity),
+where i is the total number of items and d is the donation amount.
+Fractional shares multiply the shares by 10 and d by 10 for each
+decimal place in the most precise number of shares,
+so round them if you can.
+The program fails instead of solving problems with more than -max-cells
+cells (i times d, where d is normalized to the smallest decimal place
+of all prices, costs, and the donation amount)
+or more than -max-items knapsack items (one for each lot with -whole-lots,
+-minimize-lots, -target=exact, and -per-lot-fee), so merge lots or round
+their shares if a lot with millions of share units trips the limit.
+-solver=rolling keeps only one row of d values and a bit set of the
+capacities at which it chooses each item instead, so it takes the same
+O(d + i*d/64) space but makes far fewer allocations and uses less memory
+(about 60%% as much as the default solver in the benchmarks)
+and chooses the same donation in about the same time
+(on one goroutine, so it ignores -parallel).
+-minimize-lots, -target=exact, and -per-lot-fee instead run in O(s*d) time
+(where s is the total number of shares) and take O(l*d) space
+(where l is the number of lots), and their cells are s times d.
+Each decimal place of the most precise price or cost (counting trailing
+zeros) also multiplies d by 10, so a cost with 18 decimal places
+(common for cryptocurrencies) makes almost any donation too large;
+-max-price-precision makes the program fail with a clear message
+naming the first price or cost with more decimal places than it allows
+(just as it fails on prices, costs, cash, or donation amounts
+above -max-amount and lots with more shares than -max-shares,
+which are usually typos),
+and -scale-decimals instead rounds prices and costs (half up)
+and cash and the donation amount (down) to the decimal places it specifies
+(like 2 for cents) before solving.  A coarser scale makes the knapsack
+table smaller and the program faster, but the donation is only optimal
+for the rounded prices and costs, which are what the output reports.
+If you already keep your records in whole cents, -integer-cents
+makes the program read the share prices, costs, cash amounts,
+and donation amount (in the input and on the command line)
+as integers of cents that it solves with as they are, failing
+if any of them has a fraction of a cent instead of scaling them
+(so -donation=100000 means $1,000, which is also its default
+along with a -loss-cap of 300000).  The other options' amounts,
+like -min-lot-gain, are also in cents, and so is the output
+unless you also specify -output-dollars, which converts
+its amounts, prices, costs, and cash back to dollars
+(but not those in config, which describe the options).
+To present the output in another currency, -fx-rate multiplies
+its amounts, totals, prices, costs, and cash (but not shares
+or those in config) by the number of units of that currency
+per unit of the input's currency after the program solves
+(and after -output-dollars but before -round)
+and -currency names the currency in the output.
+The conversion does not affect which lots the program donates,
+which it chooses exactly in the input's currency.
+To see why a run is slow, specify -v, which logs the exponents
+by which the program normalizes prices and shares, the knapsack capacity
+(normalized d), the numbers of lots before and after filtering,
+the number of eligible share units, and the solver's achieved value
+(in normalized units) to standard error with an "info: " prefix,
+or -vv, which also logs each knapsack problem solved
+(including those for -alternatives) and how long it took
+with a "debug: " prefix.
+-progress shows how much of each knapsack problem the solver
+has filled (as a percentage on a line of standard error
+that it erases when the problem is solved, so it never mixes
+with the output) if standard error is a terminal,
+and -force-progress shows it even if it is not.
+The solvers of -minimize-lots, -target=exact, -parallel,
+and -solver=rolling report their progress, so without them
+-progress uses -solver=rolling, which chooses the same donation.
+If a problem is too slow to solve exactly, -timeout makes the program
+donate lots chosen greedily (those with the largest capital gains,
+or losses, per dollar first) once the solvers have run for it,
+setting approximate in the output.  The greedy donation
+never exceeds the budget, but it may capture less than the best one.
+The one deadline covers every solve (including those of -max-lots,
+-alternatives, -marginal, and each charity): the solver stops
+at the deadline, the alternatives it has not found by then
+are left out, and a later solve is greedy from the start.
+Without -parallel, -timeout uses -solver=rolling, which chooses
+the same donation but can stop early.
+-fractional instead donates the best allocation that may split a share
+(the same greedy order, plus the fraction of one more share,
+to 8 decimal places of the smallest share unit, that fills the budget)
+in O(l*log(l)) time, where l is the number of lots,
+setting fractional in the output.  Its totalCapitalGains is then
+an upper bound on those of the best donation of whole shares
+(unless -objective=efficiency or -age-weight is set),
+which tells you how much a slow exact solve could gain at most.
+It is incompatible with -whole-lots, -minimize-lots, -target=exact,
+-per-lot-fee, -alternatives, -max-lots, and inputs with charities.
 
-The core algorithm runs in O(s*d) time and takes O(s*d) space,
-where s is the total number of asset shares and d is the donation amount.
+With -compare, the program instead prints a JSON object
+with two fields, gainsRecommendation and lossesRecommendation,
+each of which is an object like the output above:
+the former maximizes capital gains and the latter capital losses
+(as with -maximize-losses) with the same donation amount and options,
+so you can choose between donating appreciated lots
+and harvesting losses without running the program twice.
+It exits with status 3 only if both donations are empty.
+
+With -sweep=start:end:step, the program instead optimizes the donation
+for each amount from start to end (inclusive if the steps reach it)
+in increments of step, ignoring -donation and the input's donation,
+and prints an array (or, with -format=csv, one record per amount)
+of objects with the fields donation (the amount), totalValue,
+totalCapitalGains, and remainingBudget of each best donation,
+so you can see where larger donations stop adding much capital gains.
+It does not work with inputs that have charities
+and allows at most 10000 amounts.
+
+With -ndjson, the program instead reads a stream of independent problems
+from standard input, one input JSON object per line (skipping blank lines),
+and solves each with the same options, writing each output JSON object
+on its own line in the same order.
+It reports each line that fails on standard error with its line number
+and writes no output for it, then continues with the next line
+(or, with -fail-fast, stops).
+It exits with status 2 if any line failed and 0 otherwise
+(even if some donations are empty).
+
+With -input-dir and -output-dir, the program instead solves
+the input in each *.json file in the -input-dir directory
+(in name order, skipping *.out.json files) as an independent problem
+with the same options and writes each output JSON object
+to a file in the -output-dir directory (which it creates if necessary)
+with the input file's name but ending in .out.json
+(so accounts/ira.json produces out/ira.out.json).
+It reports each file that fails on standard error with its name
+and writes no output for it, then continues with the next file,
+and at the end it lists on standard error which files succeeded
+and which failed.
+It exits with status 2 if any file failed
+(or, with -continue-on-error, 0 anyway) and 0 otherwise
+(even if some donations are empty).
+
+Numbers in the input must use "." as the decimal separator
+and no thousands separators, but for amounts pasted from other locales
+(like 1.000,50), -decimal-separator and -thousands-separator
+convert the numeric strings (not JSON numbers, which always use ".")
+of share prices, shares, costs, budgets, and donation amounts
+in the input, the -prices file, and an explicit -donation.
+They only affect how the program reads its input:
+its output always uses "." and no thousands separators.
+
+With -check, the program instead reads and validates the input
+and the options as it would for choosing a donation
+(the lots' asset names, shares, costs, and prices,
+the assetSharePrices, and the donation amount or charities' budgets)
+without choosing one, printing a line summarizing the input
+on standard output (and not creating -output),
+so you can lint your input files quickly.
+It exits with status 0 if the input is valid and 2 otherwise
+and does not work with -compare, -sweep, or -ndjson.
+
+With -version, the program prints its version, git commit,
+and build date (so you can tell which build produced an output)
+and exits with status 0 without reading any input.
+
+The program exits with status 0 if it prints a donation,
+3 if it prints an empty donation because no lots are eligible
+or fit within the donation (explaining why on standard error),
+except that an input without lots (even {}) prints an empty donation
+with zero totals and a note and exits with status 0,
+4 if the donation does not reach -min-fill,
+and 2 if it fails.
+With -error-format=json, it prints the errors that accompany
+these statuses (but not notes, warnings, or -v messages)
+as JSON objects on lines of standard error with the following fields:
+
+- error :: string -- the error message
+- code :: number -- the exit status (for -ndjson, 2 for each failed line)
 
 Options:
 
 `)
-	flag.PrintDefaults()
+	c.flags.PrintDefaults()
 }
 
 func main() {
-	flag.Usage = printUseMessage
-	flag.Parse()
-	if !*quoteDecimals {
-		decimal.MarshalJSONWithoutQuotes = true
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// exitStatus is the value with which fail panics
+// so that run can return the status.
+type exitStatus int
+
+// nopCloser is a writer (like standard output) that Close does not close.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// lazyFile is the -output file, which it creates (or truncates)
+// only when the program first writes to it or closes it,
+// so that an invalid input or a failed solve leaves an existing file alone.
+type lazyFile struct {
+	path string
+	file *os.File
+}
+
+func (f *lazyFile) open() (err error) {
+	if f.file == nil {
+		f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	}
+	return
+}
+
+func (f *lazyFile) Write(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Write(p)
+}
+
+func (f *lazyFile) Close() error {
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.file.Close()
+}
 
-	// Parse assets from standard input.
-	var input Input
-	if err := json.NewDecoder(os.Stdin).Decode(&input); err != nil {
-		fmt.Fprintf(os.Stderr, "error decoding input JSON: %v\n", err)
-		os.Exit(2)
+// run runs the program with the command-line arguments args
+// (without the program name) and the standard streams
+// in, out, and errOut and returns its exit status.
+// Each call parses args into a new command, so calls do not share state.
+func run(args []string, in io.Reader, out, errOut io.Writer) (status int) {
+	c := newCommand(in, out, errOut)
+	defer func() {
+		if r := recover(); r != nil {
+			code, ok := r.(exitStatus)
+			if !ok {
+				panic(r)
+			}
+			status = int(code)
+		}
+	}()
+	if err := c.flags.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if c.showVersion {
+		printVersion(c.stdout)
+		return 0
+	}
+	if invalid := c.errorFormat; invalid != "text" && invalid != "json" {
+		c.errorFormat = "text"
+		c.fail(2, "invalid -error-format: %q", invalid)
 	}
-	normalizedLots, err := NewNormalizedLots(&input, *donation)
+	if c.format != "json" && c.format != "csv" && c.format != "yaml" && c.format != "table" {
+		c.fail(2, "invalid -format: %q", c.format)
+	}
+	if c.inputFormat != "json" && c.inputFormat != "yaml" {
+		c.fail(2, "invalid -input-format: %q", c.inputFormat)
+	}
+	toleranceDecimal, err := decimal.NewFromString(c.tolerance)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(2)
+		c.fail(2, "invalid -tolerance: %q", c.tolerance)
+	}
+	feePercentDecimal, err := decimal.NewFromString(c.feePercent)
+	if err != nil || feePercentDecimal.IsNegative() || !feePercentDecimal.LessThan(decimal.NewFromInt(100)) {
+		c.fail(2, "invalid -fee-percent: %q", c.feePercent)
+	}
+	ltcgRateDecimal, err := decimal.NewFromString(c.ltcgRate)
+	if err != nil || ltcgRateDecimal.IsNegative() || ltcgRateDecimal.GreaterThan(decimal.NewFromInt(1)) {
+		c.fail(2, "invalid -ltcg-rate: %q", c.ltcgRate)
+	}
+	stateRateDecimal, err := decimal.NewFromString(c.stateRate)
+	if err != nil || stateRateDecimal.IsNegative() || ltcgRateDecimal.Add(stateRateDecimal).GreaterThan(decimal.NewFromInt(1)) {
+		c.fail(2, "invalid -state-rate: %q", c.stateRate)
+	}
+	incomeRateDecimal, err := decimal.NewFromString(c.incomeRate)
+	if err != nil || incomeRateDecimal.IsNegative() || incomeRateDecimal.GreaterThan(decimal.NewFromInt(1)) {
+		c.fail(2, "invalid -income-rate: %q", c.incomeRate)
+	}
+	altToleranceDecimal, err := decimal.NewFromString(c.altTolerance)
+	if err != nil || altToleranceDecimal.IsNegative() {
+		c.fail(2, "invalid -alternatives-tolerance: %q", c.altTolerance)
+	}
+	lossCapDecimal, err := decimal.NewFromString(c.lossCap)
+	if err != nil || lossCapDecimal.IsNegative() {
+		c.fail(2, "invalid -loss-cap: %q", c.lossCap)
+	}
+	maxAmountDecimal, err := decimal.NewFromString(c.maxAmount)
+	if err != nil || maxAmountDecimal.IsNegative() {
+		c.fail(2, "invalid -max-amount: %q", c.maxAmount)
+	}
+	maxSharesDecimal, err := decimal.NewFromString(c.maxShares)
+	if err != nil || maxSharesDecimal.IsNegative() {
+		c.fail(2, "invalid -max-shares: %q", c.maxShares)
+	}
+	if c.outputDollars && !c.integerCents {
+		c.fail(2, "-output-dollars requires -integer-cents")
+	}
+	if c.integerCents {
+		// The defaults are in dollars.
+		if !c.isFlagSet("donation") {
+			c.donationAmount = "100000"
+		}
+		if !c.isFlagSet("loss-cap") {
+			lossCapDecimal = decimal.NewFromInt(300000)
+		}
+		if !c.isFlagSet("max-amount") {
+			maxAmountDecimal = maxAmountDecimal.Shift(2)
+		}
+	}
+	if c.scaleDecimals < -1 {
+		c.fail(2, "invalid -scale-decimals: %d", c.scaleDecimals)
+	}
+	if c.maxLots < 0 {
+		c.fail(2, "invalid -max-lots: %d", c.maxLots)
+	}
+	if c.maxPrecision < 0 {
+		c.fail(2, "invalid -max-price-precision: %d", c.maxPrecision)
+	}
+	marginalDecimal, err := decimal.NewFromString(c.marginal)
+	if err != nil || marginalDecimal.IsNegative() {
+		c.fail(2, "invalid -marginal: %q", c.marginal)
+	}
+	minLotGainDecimal, err := decimal.NewFromString(c.minLotGain)
+	if err != nil || minLotGainDecimal.IsNegative() {
+		c.fail(2, "invalid -min-lot-gain: %q", c.minLotGain)
+	}
+	perLotFeeDecimal, err := decimal.NewFromString(c.perLotFee)
+	if err != nil || perLotFeeDecimal.IsNegative() {
+		c.fail(2, "invalid -per-lot-fee: %q", c.perLotFee)
+	}
+	ageWeightDecimal, err := decimal.NewFromString(c.ageWeight)
+	if err != nil || ageWeightDecimal.IsNegative() {
+		c.fail(2, "invalid -age-weight: %q", c.ageWeight)
+	}
+	if rate, err := decimal.NewFromString(c.fxRate); err != nil || rate.IsNegative() {
+		c.fail(2, "invalid -fx-rate: %q", c.fxRate)
+	} else if c.currency != "" && rate.IsZero() {
+		c.fail(2, "-currency requires -fx-rate")
+	}
+	minFillDecimal, err := decimal.NewFromString(c.minFill)
+	if err != nil || minFillDecimal.IsNegative() || minFillDecimal.GreaterThan(decimal.NewFromInt(1)) {
+		c.fail(2, "invalid -min-fill: %q", c.minFill)
+	}
+	if minFillDecimal.IsPositive() && (c.compare || c.sweep != "" || c.ndjson) {
+		c.fail(2, "-min-fill does not work with -compare, -sweep, or -ndjson")
+	}
+	if c.round >= 0 && c.roundMode != donation.RoundHalfUp && c.roundMode != donation.RoundHalfEven {
+		c.fail(2, "invalid -round-mode: %q", c.roundMode)
 	}
-	normalizedLots.FilterLotsInPlace()
 
-	// Calculate the optimal donation.
-	var donationLots []Lot
-	if normalizedLots.GetTotalPrice() <= normalizedLots.donation {
-		donationLots = normalizedLots.lots
+	var sweepAmounts []decimal.Decimal
+	if c.sweep != "" {
+		if c.compare || c.ndjson || c.format == "table" {
+			c.fail(2, "-sweep does not work with -compare, -ndjson, or -format=table")
+		}
+		if sweepAmounts, err = parseSweep(c.sweep); err != nil {
+			c.fail(2, "invalid -sweep: %q: %v", c.sweep, err)
+		}
+	}
+	if c.check && (c.compare || c.sweep != "" || c.ndjson) {
+		c.fail(2, "-check does not work with -compare, -sweep, or -ndjson")
+	}
+	if c.compare && (c.format == "csv" || c.format == "table" || c.ndjson) {
+		c.fail(2, "-compare does not work with -format=csv, -format=table, or -ndjson")
+	}
+	if (c.inputDir == "") != (c.outputDir == "") {
+		c.fail(2, "-input-dir and -output-dir require each other")
+	}
+	if c.inputDir != "" && (c.ndjson || c.compare || c.sweep != "" || c.check || len(c.inputPaths) > 0 || c.outputPath != "-" || c.format != "json" || minFillDecimal.IsPositive()) {
+		c.fail(2, "-input-dir writes JSON files in -output-dir, so it does not work with -ndjson, -compare, -sweep, -check, -input, -output, -format, or -min-fill")
+	}
+	if c.ndjson && (c.format != "json" || c.inputFormat != "json" || len(c.inputPaths) > 0 || c.pricesPath != "") {
+		c.fail(2, "-ndjson reads JSON from standard input and writes JSON, so it does not work with -format, -input-format, -input, or -prices")
+	}
+	if err = c.inputSeparators().Check(); err != nil {
+		c.fail(2, "invalid -decimal-separator or -thousands-separator: %v", err)
+	}
+	if c.isFlagSet("donation") {
+		// The default -donation is always US-style.
+		c.donationAmount = c.inputSeparators().Normalize(c.donationAmount)
+	}
+	opts := donation.Options{
+		Donation:              c.donationAmount,
+		MaximizeLosses:        c.maximizeLosses,
+		LossCap:               lossCapDecimal,
+		LTCGRate:              ltcgRateDecimal,
+		StateRate:             stateRateDecimal,
+		IncomeRate:            incomeRateDecimal,
+		ExcludeAssets:         c.excludeAssets,
+		OnlyAssets:            c.onlyAssets,
+		Strict:                c.strict,
+		RejectDuplicates:      c.rejectDups,
+		MergeDuplicates:       c.mergeDups,
+		LongTermDays:          c.longTermDays,
+		IncludeShortTerm:      c.includeShortTerm,
+		CashFirst:             c.cashFirst,
+		MinLotGain:            minLotGainDecimal,
+		PerLotFee:             perLotFeeDecimal,
+		AgeWeight:             ageWeightDecimal,
+		Sort:                  c.sortLots,
+		KeepAliasNames:        c.keepAliases,
+		MaxCells:              c.maxCells,
+		MaxItems:              c.maxItems,
+		MaxPricePrecision:     int32(c.maxPrecision),
+		MaxAmount:             maxAmountDecimal,
+		MaxShares:             maxSharesDecimal,
+		MinimizeLots:          c.minimizeLots,
+		WholeLots:             c.wholeLots,
+		Fractional:            c.fractional,
+		MaxLots:               c.maxLots,
+		IntegerCents:          c.integerCents,
+		Target:                c.target,
+		Objective:             c.objective,
+		FeePercent:            feePercentDecimal,
+		Tolerance:             toleranceDecimal,
+		BasisMethod:           c.basisMethod,
+		TieBreak:              c.tieBreak,
+		Parallel:              c.parallel,
+		Solver:                c.solver,
+		Summary:               c.summary,
+		TermGains:             c.termGains,
+		CombinePriorDonations: c.combined,
+		RemainingLots:         c.showRemaining,
+		MarginalStep:          marginalDecimal,
+
+		Alternatives:          c.alternatives,
+		AlternativesTolerance: altToleranceDecimal,
+		Timeout:               c.timeout}
+	if c.explain {
+		opts.Explain = c.stderr
+	}
+	if file, ok := c.stderr.(*os.File); c.forceProgress || (c.progress && ok && isTerminal(file)) {
+		opts.Progress = (&progressMeter{w: c.stderr}).update
+	}
+	if c.scaleDecimals >= 0 {
+		scale := int32(c.scaleDecimals)
+		opts.ScaleDecimals = &scale
+	}
+	if c.verbose || c.veryVerbose {
+		opts.Logger = log.New(c.stderr, "", 0)
+		opts.Verbosity = donation.VerbosityInfo
+		if c.veryVerbose {
+			opts.Verbosity = donation.VerbosityDebug
+		}
+	}
+	if c.asOf != "" {
+		if opts.AsOf, err = donation.ParseDate(c.asOf); err != nil {
+			c.fail(2, "invalid -as-of date: %q", c.asOf)
+		}
+	}
+	if c.saleDate != "" {
+		if opts.SaleDate, err = donation.ParseDate(c.saleDate); err != nil {
+			c.fail(2, "invalid -sale-date: %q", c.saleDate)
+		}
+	}
+	var outputFile io.WriteCloser = nopCloser{c.stdout}
+	if c.outputPath != "-" && c.outputPath != "" && !c.check {
+		outputFile = &lazyFile{path: c.outputPath}
+	}
+	if c.ndjson {
+		return c.solveNDJSON(c.stdin, outputFile, opts)
+	}
+	if c.inputDir != "" {
+		return c.solveDirectory(c.inputDir, c.outputDir, opts)
+	}
+
+	// Parse assets from standard input or the input file.
+	if len(c.inputPaths) == 0 {
+		c.inputPaths = stringList{"-"}
+	}
+	inputs := make([]donation.Input, len(c.inputPaths))
+	for m, path := range c.inputPaths {
+		if inputs[m], err = c.readInput(path); err != nil {
+			c.fail(2, "%v", err)
+		}
+	}
+	input, err := donation.MergeInputs(inputs)
+	if err != nil {
+		c.fail(2, "%v", err)
+	}
+	if c.pricesPath != "" {
+		if err = c.readPrices(c.pricesPath, &input); err != nil {
+			c.fail(2, "%v", err)
+		}
+	}
+
+	if c.check {
+		summary, err := donation.Check(input, opts)
+		if err != nil {
+			c.fail(2, "%v", err)
+		}
+		fmt.Fprintf(c.stdout, "input is valid: %d lots (%d eligible), %d assets, %d recent purchases, %d prior donations, %d charities, and a donation amount of %s\n",
+			summary.Lots, summary.EligibleLots, summary.Assets, summary.RecentPurchases, summary.PriorDonations, summary.Charities, summary.DonationAmount)
+		return 0
+	}
+
+	if sweepAmounts != nil {
+		points, err := c.sweepDonations(input, opts, sweepAmounts)
+		if err != nil {
+			c.fail(2, "%v", err)
+		}
+		if c.format == "csv" {
+			err = donation.WriteSweepCSV(outputFile, points)
+		} else if c.format == "yaml" {
+			err = donation.WriteYAML(outputFile, points, c.outputQuoting())
+		} else {
+			err = c.writeJSON(outputFile, points, c.pretty)
+		}
+		if err == nil {
+			err = outputFile.Close()
+		}
+		if err != nil {
+			c.fail(2, "error writing output: %v", err)
+		}
+		return 0
+	}
+
+	// Calculate and print the optimal donation.
+	var output donation.Output
+	var comparison donation.Comparison
+	var result interface{} = &output
+	if c.compare {
+		result = &comparison
+		comparison, err = c.compareDonations(input, opts)
 	} else {
-		lots := ExpandLots(normalizedLots.lots)
-		getValue := func(a *Lot) int64 {
-			multiplier := int64(1)
-			if *maximizeLosses {
-				multiplier = int64(-1)
+		output, err = c.solve(input, opts, "")
+	}
+	if err != nil {
+		c.fail(2, "%v", err)
+	}
+	if c.format == "csv" {
+		err = donation.WriteCSV(outputFile, &output)
+	} else if c.format == "table" {
+		err = donation.WriteTable(outputFile, &output, int32(c.round), c.roundMode)
+	} else if c.format == "yaml" {
+		err = donation.WriteYAML(outputFile, result, c.outputQuoting())
+	} else {
+		err = c.writeJSON(outputFile, result, c.pretty)
+	}
+	if err == nil {
+		err = outputFile.Close()
+	}
+	if err != nil {
+		c.fail(2, "error writing output: %v", err)
+	}
+	if len(input.Lots) == 0 {
+		// An input without lots has nothing to optimize,
+		// which printNotes notes.
+		return 0
+	}
+	if c.compare {
+		gains, losses := &comparison.GainsRecommendation, &comparison.LossesRecommendation
+		if len(gains.Lots) == 0 && len(losses.Lots) == 0 {
+			c.fail(3, "no viable donation: gains: %s; losses: %s", describeExclusions(&input, gains.ExcludedLots), describeExclusions(&input, losses.ExcludedLots))
+		}
+	} else if len(output.Lots) == 0 {
+		c.fail(3, "no viable donation: %s", describeExclusions(&input, output.ExcludedLots))
+	} else if minFillDecimal.IsPositive() && output.DonationAmount.IsPositive() {
+		if fill := output.TotalValue.Div(output.DonationAmount); fill.LessThan(minFillDecimal) {
+			c.fail(4, "donation fills only %s of %s (a ratio of %s, below -min-fill %s)", output.TotalValue, output.DonationAmount, fill.StringFixed(4), minFillDecimal)
+		}
+	}
+	return 0
+}
+
+// fail reports an error (formatted as with fmt.Sprintf)
+// and makes run return status code.
+func (c *command) fail(code int, format string, args ...interface{}) {
+	c.reportError(code, fmt.Sprintf(format, args...))
+	panic(exitStatus(code))
+}
+
+// reportError writes message to standard error as a line of text
+// or, with -error-format=json, as a JSON object
+// with the exit status code.
+func (c *command) reportError(code int, message string) {
+	if c.errorFormat == "json" {
+		data, _ := json.Marshal(struct {
+			Error string `json:"error"`
+			Code  int    `json:"code"`
+		}{message, code})
+		fmt.Fprintf(c.stderr, "%s\n", data)
+		return
+	}
+	fmt.Fprintln(c.stderr, message)
+}
+
+// inputSeparators returns the separators of decimals in the input
+// from -decimal-separator and -thousands-separator.
+func (c *command) inputSeparators() donation.Separators {
+	return donation.Separators{Decimal: c.decimalSep, Thousands: c.thousandsSep}
+}
+
+// useInputDonation makes opts use input's donation amount
+// if input has one and -donation is not on the command line.
+func (c *command) useInputDonation(input *donation.Input, opts *donation.Options) {
+	if input.Donation != "" && !c.isFlagSet("donation") {
+		opts.Donation = ""
+	}
+}
+
+// outputQuoting returns the Quoting that -quote-decimals,
+// -quote-prices, and -quote-totals choose.
+func (c *command) outputQuoting() donation.Quoting {
+	return donation.Quoting{Prices: c.quotePrices || c.quoteDecimals, Totals: c.quoteTotals || c.quoteDecimals}
+}
+
+// writeJSON writes v to w as JSON (indented if indent is set)
+// followed by a newline, quoting its decimals as outputQuoting chooses.
+func (c *command) writeJSON(w io.Writer, v interface{}, indent bool) error {
+	data, err := c.outputQuoting().Marshal(v)
+	if err != nil {
+		return err
+	}
+	if indent {
+		var buffer bytes.Buffer
+		if err = json.Indent(&buffer, data, "", "  "); err != nil {
+			return err
+		}
+		data = buffer.Bytes()
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// fxRateDecimal returns the -fx-rate (which run validates).
+func (c *command) fxRateDecimal() decimal.Decimal {
+	rate, _ := decimal.NewFromString(c.fxRate)
+	return rate
+}
+
+// isFlagSet reports whether the flag with the specified name
+// is on the command line.
+func (c *command) isFlagSet(name string) (set bool) {
+	c.flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return
+}
+
+// printNotes prints notes and warnings (prefixed with prefix)
+// about input to standard error.
+func (c *command) printNotes(input *donation.Input, opts *donation.Options, prefix string) {
+	if len(input.Lots) == 0 {
+		fmt.Fprintf(c.stderr, "%snote: the input has no lots, so there is nothing to optimize\n", prefix)
+	}
+	if !opts.Strict {
+		for _, name := range input.UnusedAssets() {
+			fmt.Fprintf(c.stderr, "%snote: no lot has asset %q from assetSharePrices\n", prefix, name)
+		}
+	}
+	c.warnUnknownAssets(input, prefix, "-exclude", c.excludeAssets)
+	c.warnUnknownAssets(input, prefix, "-only", c.onlyAssets)
+}
+
+// solve optimizes the donation of input's lots with opts
+// and rounds the output as the flags specify.
+// It prefixes the notes and warnings that it prints to standard error with prefix.
+func (c *command) solve(input donation.Input, opts donation.Options, prefix string) (output donation.Output, err error) {
+	c.useInputDonation(&input, &opts)
+	c.printNotes(&input, &opts, prefix)
+	if output, err = donation.Optimize(input, opts); err != nil {
+		return
+	}
+	c.warnLosingLots(&input, &output, &opts, prefix)
+	if c.outputDollars {
+		output.CentsToDollars()
+	}
+	if rate := c.fxRateDecimal(); rate.IsPositive() {
+		output.ConvertCurrency(rate, c.currency)
+	}
+	if c.round >= 0 {
+		err = output.Round(int32(c.round), c.roundMode, c.roundPrices)
+	}
+	return
+}
+
+// warnLosingLots prints a warning (prefixed with prefix) to standard error
+// suggesting -maximize-losses if output maximizes capital gains
+// but most of input's lots have capital losses
+// (not counting those without gains or losses,
+// which -maximize-losses cannot help with either).
+func (c *command) warnLosingLots(input *donation.Input, output *donation.Output, opts *donation.Options, prefix string) {
+	if opts.MaximizeLosses {
+		return
+	}
+	losing := 0
+	for _, lot := range output.ExcludedLots {
+		// With -keep-alias-names, the lot has the name of its asset in the input.
+		price, ok := output.AssetSharePrices[input.CanonicalName(lot.AssetName)]
+		if lot.SharePrice != nil {
+			price, ok = *lot.SharePrice, true
+		}
+		if lot.Reason == donation.NoCapitalGains && ok && price.LessThan(lot.ShareCost) {
+			losing++
+		}
+	}
+	if losing*2 > len(input.Lots) {
+		fmt.Fprintf(c.stderr, "%swarning: %d of %d lots have no capital gains to donate; to realize their losses, try -maximize-losses\n", prefix, losing, len(input.Lots))
+	}
+}
+
+// compareDonations is like solve but compares the donations
+// that maximize capital gains and capital losses.
+func (c *command) compareDonations(input donation.Input, opts donation.Options) (comparison donation.Comparison, err error) {
+	c.useInputDonation(&input, &opts)
+	c.printNotes(&input, &opts, "")
+	if comparison, err = donation.Compare(input, opts); err != nil {
+		return
+	}
+	if c.outputDollars {
+		comparison.CentsToDollars()
+	}
+	if rate := c.fxRateDecimal(); rate.IsPositive() {
+		comparison.ConvertCurrency(rate, c.currency)
+	}
+	if c.round >= 0 {
+		err = comparison.Round(int32(c.round), c.roundMode, c.roundPrices)
+	}
+	return
+}
+
+// parseSweep parses a -sweep value (start:end:step)
+// into the donation amounts it names.
+func parseSweep(spec string) ([]decimal.Decimal, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("must be start:end:step")
+	}
+	var bounds [3]decimal.Decimal
+	for m, part := range parts {
+		var err error
+		if bounds[m], err = decimal.NewFromString(part); err != nil {
+			return nil, fmt.Errorf("invalid number: %q", part)
+		}
+	}
+	return donation.SweepAmounts(bounds[0], bounds[1], bounds[2])
+}
+
+// sweepDonations is like solve but optimizes the donation
+// for each of amounts (ignoring -donation and the input's donation)
+// and returns the totals of each.
+func (c *command) sweepDonations(input donation.Input, opts donation.Options, amounts []decimal.Decimal) (points []donation.SweepPoint, err error) {
+	if len(input.Charities) > 0 {
+		err = fmt.Errorf("-sweep does not work with inputs that have charities")
+		return
+	}
+	c.printNotes(&input, &opts, "")
+	opts.Explain = nil
+	for _, amount := range amounts {
+		opts.Donation = amount.String()
+		var output donation.Output
+		if output, err = donation.Optimize(input, opts); err != nil {
+			err = fmt.Errorf("donation %s: %w", amount, err)
+			return
+		}
+		if c.outputDollars {
+			output.CentsToDollars()
+		}
+		if rate := c.fxRateDecimal(); rate.IsPositive() {
+			output.ConvertCurrency(rate, c.currency)
+		}
+		if c.round >= 0 {
+			if err = output.Round(int32(c.round), c.roundMode, c.roundPrices); err != nil {
+				return
+			}
+		}
+		points = append(points, donation.NewSweepPoint(&output))
+	}
+	return
+}
+
+// solveNDJSON solves each line of r, which is an input JSON object,
+// as an independent problem and writes each output to w
+// as a JSON object on one line.
+// It reports lines that fail on standard error and skips them
+// (or stops at the first with -fail-fast),
+// and it returns the program's exit status.
+func (c *command) solveNDJSON(r io.Reader, w io.WriteCloser, opts donation.Options) int {
+	reader := bufio.NewReader(r)
+	status := 0
+	for line := 1; ; line++ {
+		data, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			c.reportError(2, fmt.Sprintf("error reading input from standard input: %v", readErr))
+			return 2
+		}
+		if len(bytes.TrimSpace(data)) != 0 {
+			prefix := fmt.Sprintf("line %d: ", line)
+			var input donation.Input
+			var output donation.Output
+			data, err := c.inputSeparators().NormalizeJSON(data)
+			if err == nil {
+				err = donation.ValidateJSON(data)
 			}
-			return multiplier * normalizedLots.UnitCapitalGains(a)
+			if err == nil {
+				err = json.Unmarshal(data, &input)
+			}
+			if err == nil {
+				output, err = c.solve(input, opts, prefix)
+			}
+			if err != nil {
+				c.reportError(2, fmt.Sprintf("%s%v", prefix, err))
+				if c.failFast {
+					return 2
+				}
+				status = 2
+			} else if err = c.writeJSON(w, &output, false); err != nil {
+				c.reportError(2, fmt.Sprintf("error writing output: %v", err))
+				return 2
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := w.Close(); err != nil {
+		c.reportError(2, fmt.Sprintf("error writing output: %v", err))
+		return 2
+	}
+	return status
+}
+
+// warnUnknownAssets prints a warning (prefixed with prefix) to standard error
+// for each of names that is not the name of an asset in input.
+func (c *command) warnUnknownAssets(input *donation.Input, prefix, flagName string, names []string) {
+	known := make(map[string]bool, len(input.AssetSharePrices))
+	for name := range input.AssetSharePrices {
+		known[name] = true
+	}
+	for _, lot := range input.Lots {
+		known[lot.AssetName] = true
+	}
+	for alias := range input.Aliases {
+		known[alias] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			fmt.Fprintf(c.stderr, "%swarning: %s names an unknown asset: %q\n", prefix, flagName, name)
 		}
-		donationLots = knapsack.Get01Solution(normalizedLots.donation, lots, func(lot *Lot) uint64 { return normalizedLots.sharePrices[lot.json.AssetName] }, getValue)
-		donationLots = DeduplicateLots(donationLots)
 	}
+}
 
-	// Print the optimal donation.
-	outputLots := make([]LotJSON, len(donationLots))
-	for m, lot := range donationLots {
-		outputLots[m] = *lot.json
-		outputLots[m].Shares = lot.shares
+// describeExclusions explains why an empty donation of input's lots
+// donates nothing given the lots that Optimize excluded.
+func describeExclusions(input *donation.Input, excluded []donation.OutputExcludedLot) string {
+	if len(excluded) == 0 {
+		return "no eligible lot fits within the donation"
+	}
+	counts := make(map[donation.ExclusionReason]int)
+	var reasons []string
+	for _, lot := range excluded {
+		if counts[lot.Reason] == 0 {
+			reasons = append(reasons, string(lot.Reason))
+		}
+		counts[lot.Reason]++
 	}
-	type Output struct {
-		Lots              []LotJSON                  `json:"donation"`
-		AssetSharePrices  map[string]decimal.Decimal `json:"assetSharePrices"`
-		TotalValue        decimal.Decimal            `json:"totalValue"`
-		TotalCapitalGains decimal.Decimal            `json:"totalCapitalGains"`
+	for m, reason := range reasons {
+		reasons[m] = fmt.Sprintf("%d %s", counts[donation.ExclusionReason(reason)], reason)
 	}
-	output := Output{Lots: outputLots, AssetSharePrices: input.AssetSharePrices}
-	for _, asset := range output.Lots {
-		shares := decimal.NewFromInt(int64(asset.Shares))
-		output.TotalValue = output.TotalValue.Add(input.AssetSharePrices[asset.AssetName].Mul(shares))
-		cg := input.UnitCapitalGains(&asset).Mul(shares)
-		output.TotalCapitalGains = output.TotalCapitalGains.Add(cg)
+	if len(excluded) < len(input.Lots) {
+		return fmt.Sprintf("no eligible lot fits within the donation (excluded lots: %s)", strings.Join(reasons, ", "))
 	}
-	json.NewEncoder(os.Stdout).Encode(output)
+	return fmt.Sprintf("no lots are eligible (excluded lots: %s)", strings.Join(reasons, ", "))
 }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the program's output")
+
+// goldenTests are the runs of the program whose standard output
+// TestGolden compares with testdata/<name>.golden.
+var goldenTests = []struct {
+	name   string
+	args   []string
+	status int
+}{
+	{"gains", nil, 0},
+	{"losses", []string{"-maximize-losses"}, 0},
+	{"quote-decimals", []string{"-quote-decimals"}, 0},
+	{"under-budget", []string{"-donation", "100000"}, 0},
+	{"over-budget", []string{"-donation", "250"}, 0},
+}
+
+// runStdin runs the program with args and standard input stdin
+// and returns its exit status, standard output, and standard error.
+func runStdin(stdin string, args ...string) (status int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	status = run(args, strings.NewReader(stdin), &out, &errOut)
+	return status, out.String(), errOut.String()
+}
+
+// runProgram runs the program with args and returns its exit status
+// and standard output, logging its standard error.
+func runProgram(t *testing.T, args ...string) (int, string) {
+	status, stdout, stderr := runStdin("", args...)
+	if stderr != "" {
+		t.Logf("%v: standard error: %s", args, stderr)
+	}
+	return status, stdout
+}
+
+// runGolden runs the program with args after the flags every golden test shares
+// and returns its exit status and standard output.
+func runGolden(t *testing.T, args []string) (int, string) {
+	return runProgram(t, append([]string{"-as-of", "2024-01-01", "-pretty", "-input", filepath.Join("testdata", "lots.json")}, args...)...)
+}
+
+func TestGolden(t *testing.T) {
+	for _, test := range goldenTests {
+		status, output := runGolden(t, test.args)
+		if status != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, status, test.status)
+		}
+		path := filepath.Join("testdata", test.name+".golden")
+		if *update {
+			if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != string(want) {
+			t.Errorf("%s: output differs from %s:\n%s", test.name, path, output)
+		}
+	}
+}
+
+func TestRunDoesNotShareFlags(t *testing.T) {
+	_, want := runGolden(t, nil)
+	if status, _ := runGolden(t, []string{"-exclude", "BND", "-maximize-losses", "-quote-decimals"}); status != 0 {
+		t.Fatalf("status %d, want 0", status)
+	}
+	if _, output := runGolden(t, nil); output != want {
+		t.Errorf("output after a run with other flags differs:\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestPretty(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{`{"donation":[{"assetName":"VTI",`, `"totalValue":988.43,`}},
+		{[]string{"-pretty"}, []string{"{\n  \"donation\": [\n    {\n      \"assetName\": \"VTI\",\n", "\n  \"totalValue\": 988.43,\n"}},
+		{[]string{"-pretty", "-quote-decimals"}, []string{"\n      \"shares\": \"9\",\n", "\n  \"totalValue\": \"988.43\",\n"}},
+		{[]string{"-quote-decimals"}, []string{`"shares":"9",`, `"totalValue":"988.43",`}},
+	}
+	for _, test := range tests {
+		status, output := runProgram(t, append([]string{"-as-of", "2024-01-01", "-input", filepath.Join("testdata", "lots.json")}, test.args...)...)
+		if status != 0 {
+			t.Errorf("%v: status %d, want 0", test.args, status)
+		}
+		if lines := strings.Count(output, "\n"); (lines > 1) != (len(test.args) > 0 && test.args[0] == "-pretty") {
+			t.Errorf("%v: output has %d lines", test.args, lines)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(output, want) {
+				t.Errorf("%v: output lacks %q:\n%s", test.args, want, output)
+			}
+		}
+	}
+}
+
+func TestUnusedAssets(t *testing.T) {
+	const input = `{"assetSharePrices": {"A": 2, "B": 3, "C": 4}, "lots": [{"assetName": "A", "date": "2020-01-02", "shares": 5, "shareCost": 1}]}`
+	tests := []struct {
+		args       []string
+		status     int
+		wantStderr string
+	}{
+		{nil, 0, "note: no lot has asset \"B\" from assetSharePrices\nnote: no lot has asset \"C\" from assetSharePrices\n"},
+		{[]string{"-strict"}, 2, "assetSharePrices has a price for B, which no lot has\n"},
+		{[]string{"-strict", "-error-format", "json"}, 2, `{"error":"assetSharePrices has a price for B, which no lot has","code":2}` + "\n"},
+	}
+	for _, test := range tests {
+		status, _, stderr := runStdin(input, append([]string{"-as-of", "2024-01-01"}, test.args...)...)
+		if status != test.status || stderr != test.wantStderr {
+			t.Errorf("%v: status %d and standard error %q, want %d and %q", test.args, status, stderr, test.status, test.wantStderr)
+		}
+	}
+}
+
+func TestEmptyInput(t *testing.T) {
+	for _, input := range []string{`{}`, `{"assetSharePrices": {}, "lots": []}`} {
+		for _, args := range [][]string{nil, {"-donation", "5%"}, {"-format", "table"}, {"-compare"}} {
+			status, stdout, stderr := runStdin(input, append([]string{"-as-of", "2024-01-01"}, args...)...)
+			if status != 0 || stderr != "note: the input has no lots, so there is nothing to optimize\n" || stdout == "" {
+				t.Errorf("%s %v: status %d, standard output %q, and standard error %q", input, args, status, stdout, stderr)
+			}
+			if args == nil && !strings.HasPrefix(stdout, `{"donation":[],"assetSharePrices":{},"donationAmount":1000,"totalValue":0,"totalCapitalGains":0,`) {
+				t.Errorf("%s: standard output %q", input, stdout)
+			}
+		}
+	}
+}
+
+func TestInputDonation(t *testing.T) {
+	const lots = `"assetSharePrices": {"A": 10}, "lots": [{"assetName": "A", "date": "2020-01-02", "shares": 100, "shareCost": 1}]`
+	tests := []struct {
+		name         string
+		input        string
+		args         []string
+		wantDonation string
+		wantAmount   string
+	}{
+		{"neither", `{` + lots + `}`, nil, "1000.00", "1000"},
+		{"input only", `{"donation": "5%", ` + lots + `}`, nil, "5%", "50"},
+		{"flag only", `{` + lots + `}`, []string{"-donation", "300"}, "300", "300"},
+		{"both", `{"donation": "5%", ` + lots + `}`, []string{"-donation", "300"}, "300", "300"},
+		{"both with the default flag", `{"donation": "5%", ` + lots + `}`, []string{"-donation", "1000.00"}, "1000.00", "1000"},
+	}
+	for _, test := range tests {
+		status, stdout, stderr := runStdin(test.input, append([]string{"-as-of", "2024-01-01"}, test.args...)...)
+		if status != 0 {
+			t.Errorf("%s: status %d: %s", test.name, status, stderr)
+			continue
+		}
+		var output struct {
+			DonationAmount json.Number `json:"donationAmount"`
+			Config         struct {
+				DonationAmount json.Number `json:"donationAmount"`
+				Donation       string      `json:"donation"`
+			} `json:"config"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if output.Config.Donation != test.wantDonation || output.Config.DonationAmount.String() != test.wantAmount || output.DonationAmount.String() != test.wantAmount {
+			t.Errorf("%s: donation %q of %s (config %s), want %q of %s", test.name, output.Config.Donation, output.DonationAmount, output.Config.DonationAmount, test.wantDonation, test.wantAmount)
+		}
+	}
+}
+
+func TestOutputKeptOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.json")
+	if err := os.WriteFile(path, []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		input  string
+		args   []string
+		status int
+	}{
+		{"invalid JSON", `{"lots": [`, nil, 2},
+		{"invalid input", `{"assetSharePrices": {"A": -1}, "lots": [{"assetName": "A", "date": "2020-01-02", "shares": 1, "shareCost": 1}]}`, nil, 2},
+		{"invalid donation", `{"assetSharePrices": {"A": 1}, "lots": [{"assetName": "A", "date": "2020-01-02", "shares": 1, "shareCost": 1}]}`, []string{"-donation", "x"}, 2},
+	}
+	for _, test := range tests {
+		status, _, _ := runStdin(test.input, append([]string{"-as-of", "2024-01-01", "-output", path}, test.args...)...)
+		if status != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, status, test.status)
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != "previous\n" {
+			t.Errorf("%s: output file has %q (error %v), want it unchanged", test.name, data, err)
+		}
+	}
+	if status, _ := runGolden(t, []string{"-output", path}); status != 0 {
+		t.Fatalf("status %d, want 0", status)
+	}
+	_, want := runGolden(t, nil)
+	if data, err := os.ReadFile(path); err != nil || string(data) != want {
+		t.Errorf("output file has %q (error %v), want %q", data, err, want)
+	}
+}
+
+func TestLosingLotsWarning(t *testing.T) {
+	const input = `{"assetSharePrices": {"A": 2}, "aliases": {"B": "A"}, "lots": [
+		{"assetName": "A", "date": "2020-01-02", "shares": 5, "shareCost": 3},
+		{"assetName": "B", "date": "2020-01-02", "shares": 5, "shareCost": 4},
+		{"assetName": "B", "date": "2020-01-02", "shares": 5, "shareCost": 1}]}`
+	const warning = "warning: 2 of 3 lots have no capital gains to donate; to realize their losses, try -maximize-losses\n"
+	for _, args := range [][]string{nil, {"-keep-alias-names"}} {
+		status, _, stderr := runStdin(input, append([]string{"-as-of", "2024-01-01"}, args...)...)
+		if status != 0 || stderr != warning {
+			t.Errorf("%v: status %d and standard error %q, want 0 and %q", args, status, stderr, warning)
+		}
+	}
+}
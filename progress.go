@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressInterval is the least time between progress updates.
+const progressInterval = 100 * time.Millisecond
+
+// progressMeter prints the percentage of each knapsack problem's cells
+// that the solver has filled (see donation.Options.Progress)
+// on one line of w that it rewrites and erases when the problem is solved.
+type progressMeter struct {
+	w       io.Writer
+	mu      sync.Mutex
+	last    time.Time
+	percent uint64
+	shown   bool
+}
+
+// update shows that done of total cells are filled.
+// It waits progressInterval after a problem's first update
+// before showing anything so that quick problems do not flicker.
+func (meter *progressMeter) update(done, total uint64) {
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+	now := time.Now()
+	if done >= total {
+		if meter.shown {
+			fmt.Fprint(meter.w, "\r               \r")
+		}
+		meter.last, meter.shown = time.Time{}, false
+		return
+	}
+	if meter.last.IsZero() {
+		meter.last = now
+		return
+	}
+	percent := done * 100 / total
+	if (percent != meter.percent || !meter.shown) && now.Sub(meter.last) >= progressInterval {
+		fmt.Fprintf(meter.w, "\rprogress: %3d%%", percent)
+		meter.last, meter.percent, meter.shown = now, percent, true
+	}
+}
+
+// isTerminal reports whether f is a terminal (or another character device).
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
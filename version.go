@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// version, commit, and buildDate describe the build.
+// Release builds set them with -ldflags, like
+// -ldflags "-X main.version=v1.2.0 -X main.commit=abc1234 -X main.buildDate=2024-01-02T03:04:05Z";
+// otherwise printVersion takes what it can from the module's build info.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// printVersion writes the program's version, git commit,
+// and build date (or, from the build info, the commit's date) to w,
+// using "unknown" for what it cannot find.
+func printVersion(w io.Writer) {
+	v, c, d := version, commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		modified := false
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = setting.Value
+				}
+			case "vcs.time":
+				if d == "" {
+					d = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && commit == "" && c != "" {
+			c += " (modified)"
+		}
+	}
+	unknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	if v == "" {
+		v = "devel"
+	}
+	fmt.Fprintf(w, "choose-donation-assets %s\ncommit: %s\nbuilt: %s\n", v, unknown(c), unknown(d))
+}